@@ -1,6 +1,7 @@
 package stats
 
 import (
+	"errors"
 	"fmt"
 	"time"
 
@@ -12,10 +13,29 @@ type Config struct {
 	DB         string
 	Interval   time.Duration
 	Precision  string
+	Tags       map[string]string
+	Sinks      []Sink
+	Rollups    []RollupConfig
+}
+
+// RollupConfig 描述一个比Interval更粗的聚合窗口，窗口按整点对齐，
+// 数据写入独立的RetentionPolicy，或者在measurement后追加Suffix。
+// 窗口内Add*写入的字段累加，Set*写入的字段取最后一个周期的值
+type RollupConfig struct {
+	Interval        time.Duration
+	RetentionPolicy string
+	Suffix          string
+}
+
+type rollup struct {
+	RollupConfig
+	items ItemSet
+	kinds fieldKinds
 }
 
 type Transaction struct {
 	items     ItemSet
+	kinds     fieldKinds
 	buffer    *Buffer
 	submitted bool
 }
@@ -32,28 +52,64 @@ func (transaction *Transaction) Submit() error {
 	return nil
 }
 
-func (transaction *Transaction) AddFloat(measurement string, tags *Tags, name string, value float64) {
-	if transaction != nil {
-		transaction.items.AddFloat(measurement, tags, name, value)
+func (transaction *Transaction) AddFloat(measurement string, tags *Tags, name string, value float64) error {
+	if transaction == nil {
+		return nil
 	}
+	if err := transaction.kinds.check(measurement, name, kindAdd); err != nil {
+		return err
+	}
+	return transaction.kinds.record(measurement, name, kindAdd, transaction.items.AddFloat(measurement, tags, name, value))
 }
 
-func (transaction *Transaction) AddInt(measurement string, tags *Tags, name string, value int64) {
-	if transaction != nil {
-		transaction.items.AddInt(measurement, tags, name, value)
+func (transaction *Transaction) AddInt(measurement string, tags *Tags, name string, value int64) error {
+	if transaction == nil {
+		return nil
 	}
+	if err := transaction.kinds.check(measurement, name, kindAdd); err != nil {
+		return err
+	}
+	return transaction.kinds.record(measurement, name, kindAdd, transaction.items.AddInt(measurement, tags, name, value))
 }
 
-func (transaction *Transaction) SetFloat(measurement string, tags *Tags, name string, value float64) {
-	if transaction != nil {
-		transaction.items.SetFloat(measurement, tags, name, value)
+func (transaction *Transaction) SetFloat(measurement string, tags *Tags, name string, value float64) error {
+	if transaction == nil {
+		return nil
 	}
+	if err := transaction.kinds.check(measurement, name, kindSet); err != nil {
+		return err
+	}
+	return transaction.kinds.record(measurement, name, kindSet, transaction.items.SetFloat(measurement, tags, name, value))
 }
 
-func (transaction *Transaction) SetInt(measurement string, tags *Tags, name string, value int64) {
-	if transaction != nil {
-		transaction.items.SetInt(measurement, tags, name, value)
+func (transaction *Transaction) SetInt(measurement string, tags *Tags, name string, value int64) error {
+	if transaction == nil {
+		return nil
 	}
+	if err := transaction.kinds.check(measurement, name, kindSet); err != nil {
+		return err
+	}
+	return transaction.kinds.record(measurement, name, kindSet, transaction.items.SetInt(measurement, tags, name, value))
+}
+
+func (transaction *Transaction) SetBool(measurement string, tags *Tags, name string, value bool) error {
+	if transaction == nil {
+		return nil
+	}
+	if err := transaction.kinds.check(measurement, name, kindSet); err != nil {
+		return err
+	}
+	return transaction.kinds.record(measurement, name, kindSet, transaction.items.SetBool(measurement, tags, name, value))
+}
+
+func (transaction *Transaction) SetString(measurement string, tags *Tags, name string, value string) error {
+	if transaction == nil {
+		return nil
+	}
+	if err := transaction.kinds.check(measurement, name, kindSet); err != nil {
+		return err
+	}
+	return transaction.kinds.record(measurement, name, kindSet, transaction.items.SetString(measurement, tags, name, value))
 }
 
 type MeasurementTags struct {
@@ -68,28 +124,39 @@ func NewMeasurementTags(measurement string, tags ...string) *MeasurementTags {
 	}
 }
 
-func (mt *MeasurementTags) AddInt(tx *Transaction, name string, value int64) {
-	tx.AddInt(mt.measurement, mt.tags, name, value)
+func (mt *MeasurementTags) AddInt(tx *Transaction, name string, value int64) error {
+	return tx.AddInt(mt.measurement, mt.tags, name, value)
 }
 
-func (mt *MeasurementTags) AddFloat(tx *Transaction, name string, value float64) {
-	tx.AddFloat(mt.measurement, mt.tags, name, value)
+func (mt *MeasurementTags) AddFloat(tx *Transaction, name string, value float64) error {
+	return tx.AddFloat(mt.measurement, mt.tags, name, value)
 }
 
-func (mt *MeasurementTags) SetInt(tx *Transaction, name string, value int64) {
-	tx.SetInt(mt.measurement, mt.tags, name, value)
+func (mt *MeasurementTags) SetInt(tx *Transaction, name string, value int64) error {
+	return tx.SetInt(mt.measurement, mt.tags, name, value)
 }
 
-func (mt *MeasurementTags) SetFloat(tx *Transaction, name string, value float64) {
-	tx.SetFloat(mt.measurement, mt.tags, name, value)
+func (mt *MeasurementTags) SetFloat(tx *Transaction, name string, value float64) error {
+	return tx.SetFloat(mt.measurement, mt.tags, name, value)
+}
+
+func (mt *MeasurementTags) SetBool(tx *Transaction, name string, value bool) error {
+	return tx.SetBool(mt.measurement, mt.tags, name, value)
+}
+
+func (mt *MeasurementTags) SetString(tx *Transaction, name string, value string) error {
+	return tx.SetString(mt.measurement, mt.tags, name, value)
 }
 
 type Buffer struct {
-	client         client.Client
+	sinks          []Sink
+	tags           map[string]string
 	db             string
 	precision      string
 	interval       time.Duration
 	items          ItemSet
+	kinds          fieldKinds
+	rollups        []*rollup
 	errorCallback  func(error)
 	submitCallback func(ItemSet)
 	transactions   chan *Transaction
@@ -97,15 +164,48 @@ type Buffer struct {
 }
 
 func NewBuffer(config Config, errorCallback func(error), submitCallback func(ItemSet)) (*Buffer, error) {
-	client, err := client.NewHTTPClient(config.HTTPConfig)
-	if err != nil {
-		return nil, fmt.Errorf("创建InfluxDB客户端出错: %s", err.Error())
+	if config.Interval <= 0 {
+		return nil, fmt.Errorf("无效的统计周期: %s", config.Interval)
+	}
+	rollups := make([]*rollup, 0, len(config.Rollups))
+	for _, rollupConfig := range config.Rollups {
+		if rollupConfig.Interval <= config.Interval || rollupConfig.Interval%config.Interval != 0 {
+			return nil, fmt.Errorf("聚合窗口必须是统计周期的整数倍: interval=%s, rollup=%s",
+				config.Interval, rollupConfig.Interval)
+		} else if rollupConfig.RetentionPolicy == "" && rollupConfig.Suffix == "" {
+			return nil, fmt.Errorf("聚合窗口缺少RetentionPolicy或Suffix: rollup=%s", rollupConfig.Interval)
+		}
+		rollups = append(rollups, &rollup{
+			RollupConfig: rollupConfig,
+			items:        make(map[string]map[string]map[string]interface{}),
+			kinds:        make(fieldKinds),
+		})
+	}
+	sinks := make([]Sink, 0, len(config.Sinks)+1)
+	if config.HTTPConfig.Addr != "" {
+		sink := &HTTPSink{HTTPConfig: config.HTTPConfig}
+		if err := sink.initialize(); err != nil {
+			return nil, err
+		}
+		sinks = append(sinks, sink)
+	}
+	for _, sink := range config.Sinks {
+		if sink != nil {
+			sinks = append(sinks, sink)
+		}
+	}
+	if len(sinks) == 0 {
+		return nil, fmt.Errorf("未配置统计数据输出")
 	}
 	buffer := &Buffer{
-		client:         client,
+		sinks:          sinks,
+		tags:           config.Tags,
 		db:             config.DB,
+		precision:      config.Precision,
 		interval:       config.Interval,
 		items:          make(map[string]map[string]map[string]interface{}),
+		kinds:          make(fieldKinds),
+		rollups:        rollups,
 		transactions:   make(chan *Transaction, 64),
 		submitTicker:   make(chan time.Time, 1),
 		errorCallback:  errorCallback,
@@ -125,28 +225,64 @@ func NewBuffer(config Config, errorCallback func(error), submitCallback func(Ite
 	return buffer, nil
 }
 
-func (buffer *Buffer) AddFloat(measurement string, tags *Tags, name string, value float64) {
-	if buffer != nil {
-		buffer.items.AddFloat(measurement, tags, name, value)
+func (buffer *Buffer) AddFloat(measurement string, tags *Tags, name string, value float64) error {
+	if buffer == nil {
+		return nil
+	}
+	if err := buffer.kinds.check(measurement, name, kindAdd); err != nil {
+		return err
+	}
+	return buffer.kinds.record(measurement, name, kindAdd, buffer.items.AddFloat(measurement, tags, name, value))
+}
+
+func (buffer *Buffer) AddInt(measurement string, tags *Tags, name string, value int64) error {
+	if buffer == nil {
+		return nil
 	}
+	if err := buffer.kinds.check(measurement, name, kindAdd); err != nil {
+		return err
+	}
+	return buffer.kinds.record(measurement, name, kindAdd, buffer.items.AddInt(measurement, tags, name, value))
 }
 
-func (buffer *Buffer) AddInt(measurement string, tags *Tags, name string, value int64) {
-	if buffer != nil {
-		buffer.items.AddInt(measurement, tags, name, value)
+func (buffer *Buffer) SetFloat(measurement string, tags *Tags, name string, value float64) error {
+	if buffer == nil {
+		return nil
 	}
+	if err := buffer.kinds.check(measurement, name, kindSet); err != nil {
+		return err
+	}
+	return buffer.kinds.record(measurement, name, kindSet, buffer.items.SetFloat(measurement, tags, name, value))
 }
 
-func (buffer *Buffer) SetFloat(measurement string, tags *Tags, name string, value float64) {
-	if buffer != nil {
-		buffer.items.SetFloat(measurement, tags, name, value)
+func (buffer *Buffer) SetInt(measurement string, tags *Tags, name string, value int64) error {
+	if buffer == nil {
+		return nil
 	}
+	if err := buffer.kinds.check(measurement, name, kindSet); err != nil {
+		return err
+	}
+	return buffer.kinds.record(measurement, name, kindSet, buffer.items.SetInt(measurement, tags, name, value))
 }
 
-func (buffer *Buffer) SetInt(measurement string, tags *Tags, name string, value int64) {
-	if buffer != nil {
-		buffer.items.SetInt(measurement, tags, name, value)
+func (buffer *Buffer) SetBool(measurement string, tags *Tags, name string, value bool) error {
+	if buffer == nil {
+		return nil
 	}
+	if err := buffer.kinds.check(measurement, name, kindSet); err != nil {
+		return err
+	}
+	return buffer.kinds.record(measurement, name, kindSet, buffer.items.SetBool(measurement, tags, name, value))
+}
+
+func (buffer *Buffer) SetString(measurement string, tags *Tags, name string, value string) error {
+	if buffer == nil {
+		return nil
+	}
+	if err := buffer.kinds.check(measurement, name, kindSet); err != nil {
+		return err
+	}
+	return buffer.kinds.record(measurement, name, kindSet, buffer.items.SetString(measurement, tags, name, value))
 }
 
 func (buffer *Buffer) NewTransaction() *Transaction {
@@ -155,6 +291,7 @@ func (buffer *Buffer) NewTransaction() *Transaction {
 	}
 	return &Transaction{
 		items:  make(map[string]map[string]map[string]interface{}),
+		kinds:  make(fieldKinds),
 		buffer: buffer,
 	}
 }
@@ -170,74 +307,99 @@ func (buffer *Buffer) submit(timestamp time.Time) error {
 	if buffer.submitCallback != nil {
 		buffer.submitCallback(buffer.items)
 	}
+	// 先把基础周期的数据并入各聚合窗口，基础周期写出失败时不影响窗口数据
+	var errs []error
+	for _, rollup := range buffer.rollups {
+		if err := rollup.items.merge(rollup.kinds, buffer.items, buffer.kinds); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if err := buffer.write(buffer.items, timestamp, "", ""); err != nil {
+		errs = append(errs, err)
+	}
+	// 窗口结束时写出并清空
+	end := timestamp.Add(buffer.interval)
+	for _, rollup := range buffer.rollups {
+		if !end.Truncate(rollup.Interval).Equal(end) {
+			continue
+		}
+		items := rollup.items
+		rollup.items = make(map[string]map[string]map[string]interface{})
+		rollup.kinds = make(fieldKinds)
+		if err := buffer.write(items, end.Add(-rollup.Interval), rollup.RetentionPolicy, rollup.Suffix); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (buffer *Buffer) write(items ItemSet, timestamp time.Time, retentionPolicy, suffix string) error {
 	points, err := client.NewBatchPoints(client.BatchPointsConfig{
-		Precision: buffer.precision,
-		Database:  buffer.db,
+		Precision:       buffer.precision,
+		Database:        buffer.db,
+		RetentionPolicy: retentionPolicy,
 	})
 	if err != nil {
 		return fmt.Errorf("创建BatchPoints出错: %s", err.Error())
 	}
-	for measurement, tagItems := range buffer.items {
+	for measurement, tagItems := range items {
 		for tag, fields := range tagItems {
-			point, err := client.NewPoint(measurement, tagsCache[tag], fields, timestamp)
+			tags := buffer.pointTags(tag)
+			point, err := client.NewPoint(measurement+suffix, tags, fields, timestamp)
 			if err != nil {
 				return fmt.Errorf("创建Point出错: measurement=%q, tags=%v, fields=%v, timestamp=%s, error=%q",
-					measurement, tagsCache[tag], fields, timestamp, err.Error())
+					measurement+suffix, tags, fields, timestamp, err.Error())
 			}
 			points.AddPoint(point)
 		}
 	}
-	go func() {
-		if err := buffer.client.Write(points); err != nil {
-			buffer.onError(fmt.Errorf("写入InfluxDB数据出错: %s", err.Error()))
-		}
-	}()
+	for _, sink := range buffer.sinks {
+		go func(sink Sink) {
+			if err := sink.Write(points); err != nil {
+				buffer.onError(fmt.Errorf("写入InfluxDB数据出错: %s", err.Error()))
+			}
+		}(sink)
+	}
 	return nil
 }
 
+// pointTags 合并默认标签，统计项自身的标签优先
+func (buffer *Buffer) pointTags(tag string) map[string]string {
+	if len(buffer.tags) == 0 {
+		return tagsCache[tag]
+	}
+	tags := make(map[string]string, len(buffer.tags)+len(tagsCache[tag]))
+	for name, value := range buffer.tags {
+		tags[name] = value
+	}
+	for name, value := range tagsCache[tag] {
+		tags[name] = value
+	}
+	return tags
+}
+
 func (buffer *Buffer) readTransactions() {
 	for {
 		select {
 		case transaction := <-buffer.transactions:
 			// fmt.Println("get transaction")
-			buffer.add(transaction.items)
+			buffer.add(transaction.items, transaction.kinds)
 		case timestamp := <-buffer.submitTicker:
 			// fmt.Println("get submit timestamp:", timestamp)
-			buffer.submit(timestamp)
+			if err := buffer.submit(timestamp); err != nil {
+				buffer.onError(err)
+			}
 		}
 	}
 }
 
-func (buffer *Buffer) add(items ItemSet) {
-	for measurement, tagItems := range items {
-		bufTagItems := buffer.items[measurement]
-		if bufTagItems == nil {
-			bufTagItems = make(map[string]map[string]interface{})
-			buffer.items[measurement] = bufTagItems
-		}
-		for tag, fields := range tagItems {
-			bufFields := bufTagItems[tag]
-			if bufFields == nil {
-				bufFields = make(map[string]interface{})
-				bufTagItems[tag] = bufFields
-			}
-			for name, value := range fields {
-				bufValue := bufFields[name]
-				if bufValue == nil {
-					bufFields[name] = value
-				} else {
-					switch v := value.(type) {
-					case int64:
-						bufFields[name] = bufValue.(int64) + v
-					case float64:
-						bufFields[name] = bufValue.(float64) + v
-					}
-				}
-			}
-		}
+func (buffer *Buffer) add(items ItemSet, kinds fieldKinds) {
+	if err := buffer.items.merge(buffer.kinds, items, kinds); err != nil {
+		buffer.onError(err)
 	}
 }
 
 func (buffer *Buffer) reset() {
 	buffer.items = make(map[string]map[string]map[string]interface{})
+	buffer.kinds = make(fieldKinds)
 }
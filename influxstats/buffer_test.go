@@ -0,0 +1,189 @@
+package stats
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/influxdata/influxdb/client/v2"
+	"github.com/yangchenxing/foochow/structs"
+)
+
+type testSink chan client.BatchPoints
+
+func (sink testSink) Write(points client.BatchPoints) error {
+	sink <- points
+	return nil
+}
+
+func newTestBuffer(sink testSink, rollups ...RollupConfig) *Buffer {
+	buffer := &Buffer{
+		sinks:    []Sink{sink},
+		interval: time.Minute,
+		items:    make(ItemSet),
+		kinds:    make(fieldKinds),
+	}
+	for _, config := range rollups {
+		buffer.rollups = append(buffer.rollups, &rollup{RollupConfig: config, items: make(ItemSet), kinds: make(fieldKinds)})
+	}
+	return buffer
+}
+
+// receive 读取一次submit写出的n批数据，按measurement索引
+func receive(t *testing.T, sink testSink, n int) map[string]*client.Point {
+	points := make(map[string]*client.Point)
+	for i := 0; i < n; i++ {
+		select {
+		case batch := <-sink:
+			for _, point := range batch.Points() {
+				points[point.Name()] = point
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("received %d of %d batches", i, n)
+		}
+	}
+	select {
+	case <-sink:
+		t.Fatalf("more than %d batches written: %v", n, points)
+	default:
+	}
+	return points
+}
+
+func TestRollup(t *testing.T) {
+	sink := make(testSink, 4)
+	buffer := newTestBuffer(sink, RollupConfig{Interval: 5 * time.Minute, Suffix: "_5m"})
+	tags := NewTags("host", "a")
+	start := time.Date(2016, 1, 2, 15, 3, 0, 0, time.UTC)
+	for i := 0; i < 7; i++ {
+		timestamp := start.Add(time.Duration(i) * time.Minute)
+		buffer.AddInt("rollup", tags, "count", 1)
+		buffer.SetInt("rollup", tags, "gauge", int64(10+i))
+		if err := buffer.submit(timestamp); err != nil {
+			t.Fatal(err)
+		}
+		// 15:04和15:09的周期结束时写出窗口，第一个窗口只包含15:03和15:04两个周期
+		window, ok := map[int]struct {
+			start        time.Time
+			count, gauge int64
+		}{
+			1: {time.Date(2016, 1, 2, 15, 0, 0, 0, time.UTC), 2, 11},
+			6: {time.Date(2016, 1, 2, 15, 5, 0, 0, time.UTC), 5, 16},
+		}[i]
+		want := 1
+		if ok {
+			want = 2
+		}
+		points := receive(t, sink, want)
+		if point := points["rollup"]; point == nil || !point.Time().Equal(timestamp) {
+			t.Fatalf("period %s: base point = %v", timestamp, point)
+		}
+		if !ok {
+			continue
+		}
+		point := points["rollup_5m"]
+		if point == nil || !point.Time().Equal(window.start) {
+			t.Fatalf("period %s: rollup point = %v", timestamp, point)
+		}
+		if fields, _ := point.Fields(); fields["count"] != window.count || fields["gauge"] != window.gauge {
+			t.Errorf("period %s: rollup fields = %v; want count=%d gauge=%d", timestamp, fields, window.count, window.gauge)
+		}
+	}
+	if len(buffer.rollups[0].items) != 0 {
+		t.Errorf("rollup items not reset: %v", buffer.rollups[0].items)
+	}
+}
+
+func TestRollupFailedWrite(t *testing.T) {
+	sink := make(testSink, 4)
+	buffer := newTestBuffer(sink, RollupConfig{Interval: 2 * time.Minute, Suffix: "_2m"})
+	tags := NewTags("host", "a")
+	// NaN无法写出，基础周期写出失败，但这一周期的数据仍然进入聚合窗口
+	buffer.AddInt("failed", tags, "count", 1)
+	buffer.SetFloat("failed", tags, "gauge", math.NaN())
+	if err := buffer.submit(time.Date(2016, 1, 2, 15, 0, 0, 0, time.UTC)); err == nil {
+		t.Fatal("submit succeeded with a NaN field")
+	}
+	receive(t, sink, 0)
+	buffer.AddInt("failed", tags, "count", 1)
+	buffer.SetFloat("failed", tags, "gauge", 1)
+	if err := buffer.submit(time.Date(2016, 1, 2, 15, 1, 0, 0, time.UTC)); err != nil {
+		t.Fatal(err)
+	}
+	point := receive(t, sink, 2)["failed_2m"]
+	if fields, _ := point.Fields(); fields["count"] != int64(2) || fields["gauge"] != 1.0 {
+		t.Errorf("rollup fields = %v; want count=2 gauge=1", fields)
+	}
+}
+
+func TestBufferAddSetFields(t *testing.T) {
+	buffer := newTestBuffer(make(testSink, 1))
+	tags := NewTags("host", "a")
+	for i := int64(1); i <= 3; i++ {
+		transaction := buffer.NewTransaction()
+		transaction.AddInt("transaction", tags, "count", i)
+		transaction.SetFloat("transaction", tags, "gauge", float64(i))
+		buffer.add(transaction.items, transaction.kinds)
+	}
+	fields := buffer.items["transaction"][tags.String()]
+	if fields["count"] != int64(6) || fields["gauge"] != 3.0 {
+		t.Errorf("fields = %v; want count=6 gauge=3", fields)
+	}
+}
+
+func TestBufferConfig(t *testing.T) {
+	var data map[string]interface{}
+	if _, err := toml.Decode(`
+DB = "stats"
+Interval = "1m"
+Precision = "s"
+Tags = {host = "a"}
+
+[[Sinks]]
+type = "stderr"
+
+[[Sinks]]
+type = "udp"
+Addr = "127.0.0.1:8089"
+
+[[Rollups]]
+Interval = "1h"
+RetentionPolicy = "hourly"
+`, &data); err != nil {
+		t.Fatal(err)
+	}
+	var config Config
+	if err := structs.UnmarshalMap(&config, data); err != nil {
+		t.Fatal(err)
+	}
+	if config.DB != "stats" || config.Interval != time.Minute || config.Tags["host"] != "a" ||
+		len(config.Rollups) != 1 || config.Rollups[0].Interval != time.Hour || config.Rollups[0].RetentionPolicy != "hourly" {
+		t.Errorf("config = %+v", config)
+	}
+	if len(config.Sinks) != 2 {
+		t.Fatalf("sinks = %v", config.Sinks)
+	}
+	if _, ok := config.Sinks[0].(*WriterSink); !ok {
+		t.Errorf("sinks[0] = %T; want *WriterSink", config.Sinks[0])
+	}
+	if sink, ok := config.Sinks[1].(*UDPSink); !ok || sink.Addr != "127.0.0.1:8089" {
+		t.Errorf("sinks[1] = %#v; want *UDPSink", config.Sinks[1])
+	}
+
+	for _, rollups := range [][]RollupConfig{
+		{{Interval: 90 * time.Second, Suffix: "_90s"}},
+		{{Interval: time.Minute, Suffix: "_1m"}},
+		{{Interval: time.Hour}},
+	} {
+		config.Rollups = rollups
+		if _, err := NewBuffer(config, nil, nil); err == nil {
+			t.Errorf("NewBuffer succeeded with rollups %+v", rollups)
+		}
+	}
+	if err := structs.UnmarshalMap(&config, map[string]interface{}{
+		"Sinks": []interface{}{map[string]interface{}{"type": "kafka"}},
+	}); err == nil {
+		t.Error("UnmarshalMap succeeded with unknown sink type")
+	}
+}
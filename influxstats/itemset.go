@@ -1,5 +1,43 @@
 package stats
 
+import (
+	"fmt"
+)
+
+// fieldKind 是字段的聚合方式，同一measurement的同名字段只能用一种方式写入
+type fieldKind int
+
+const (
+	// kindAdd 由Add*写入，合并时累加
+	kindAdd fieldKind = iota + 1
+	// kindSet 由Set*写入，合并时取后到的值
+	kindSet
+)
+
+// fieldKinds 记录每个字段的聚合方式，键为measurement和字段名，
+// 与所属的ItemSet一起创建和清空
+type fieldKinds map[string]fieldKind
+
+func fieldKey(measurement, name string) string {
+	return measurement + "\x00" + name
+}
+
+// check 在字段已经以其他方式写入时返回错误
+func (kinds fieldKinds) check(measurement, name string, kind fieldKind) error {
+	if old := kinds[fieldKey(measurement, name)]; old != 0 && old != kind {
+		return fmt.Errorf("统计字段聚合方式冲突: measurement=%q, name=%q", measurement, name)
+	}
+	return nil
+}
+
+// record 在写入成功后记录字段的聚合方式
+func (kinds fieldKinds) record(measurement, name string, kind fieldKind, err error) error {
+	if err == nil {
+		kinds[fieldKey(measurement, name)] = kind
+	}
+	return err
+}
+
 type ItemSet map[string]map[string]map[string]interface{}
 
 func (set ItemSet) getFields(measurement string, tags *Tags) map[string]interface{} {
@@ -16,44 +54,193 @@ func (set ItemSet) getFields(measurement string, tags *Tags) map[string]interfac
 	return fields
 }
 
-func (set ItemSet) AddFloat(measurement string, tags *Tags, name string, value float64) {
+// merge 按统计项的聚合规则把items并入set，kinds和itemKinds分别是两者的聚合方式：
+// Add*写入的数值字段累加，Set*写入的字段（包括全部布尔和字符串字段）取后到的值，
+// 因此聚合窗口中的Set字段为窗口内最后一个周期的值。
+// 类型或聚合方式冲突的字段保留原值，返回第一个冲突的错误
+func (set ItemSet) merge(kinds fieldKinds, items ItemSet, itemKinds fieldKinds) error {
+	var conflict error
+	for measurement, tagItems := range items {
+		setTagItems := set[measurement]
+		if setTagItems == nil {
+			setTagItems = make(map[string]map[string]interface{})
+			set[measurement] = setTagItems
+		}
+		for tag, fields := range tagItems {
+			setFields := setTagItems[tag]
+			if setFields == nil {
+				setFields = make(map[string]interface{})
+				setTagItems[tag] = setFields
+			}
+			for name, value := range fields {
+				key := fieldKey(measurement, name)
+				kind := itemKinds[key]
+				if err := kinds.check(measurement, name, kind); err != nil {
+					if conflict == nil {
+						conflict = err
+					}
+					continue
+				}
+				setValue := setFields[name]
+				if setValue == nil {
+					setFields[name] = value
+					kinds[key] = kind
+					continue
+				}
+				ok := false
+				add := kind != kindSet
+				switch v := value.(type) {
+				case int64:
+					var old int64
+					if old, ok = setValue.(int64); ok && add {
+						setFields[name] = old + v
+					} else if ok {
+						setFields[name] = v
+					}
+				case float64:
+					var old float64
+					if old, ok = setValue.(float64); ok && add {
+						setFields[name] = old + v
+					} else if ok {
+						setFields[name] = v
+					}
+				case bool:
+					if _, ok = setValue.(bool); ok {
+						setFields[name] = v
+					}
+				case string:
+					if _, ok = setValue.(string); ok {
+						setFields[name] = v
+					}
+				}
+				if !ok {
+					if conflict == nil {
+						conflict = typeConflict(measurement, tag, name, setValue, value)
+					}
+					continue
+				}
+				kinds[key] = kind
+			}
+		}
+	}
+	return conflict
+}
+
+func (set ItemSet) AddFloat(measurement string, tags *Tags, name string, value float64) error {
 	fields := set.getFields(measurement, tags)
-	if v, ok := fields[name].(float64); ok {
-		fields[name] = v + value
-	} else {
+	switch v := fields[name].(type) {
+	case nil:
 		fields[name] = value
+	case float64:
+		fields[name] = v + value
+	default:
+		return typeConflict(measurement, tags.String(), name, v, value)
 	}
+	return nil
 }
 
-func (set ItemSet) AddInt(measurement string, tags *Tags, name string, value int64) {
+func (set ItemSet) AddInt(measurement string, tags *Tags, name string, value int64) error {
 	fields := set.getFields(measurement, tags)
-	if v, ok := fields[name].(int64); ok {
+	switch v := fields[name].(type) {
+	case nil:
+		fields[name] = value
+	case int64:
 		fields[name] = v + value
-	} else {
+	default:
+		return typeConflict(measurement, tags.String(), name, v, value)
+	}
+	return nil
+}
+
+func (set ItemSet) SetFloat(measurement string, tags *Tags, name string, value float64) error {
+	fields := set.getFields(measurement, tags)
+	switch v := fields[name].(type) {
+	case nil, float64:
+		fields[name] = value
+	default:
+		return typeConflict(measurement, tags.String(), name, v, value)
+	}
+	return nil
+}
+
+func (set ItemSet) SetInt(measurement string, tags *Tags, name string, value int64) error {
+	fields := set.getFields(measurement, tags)
+	switch v := fields[name].(type) {
+	case nil, int64:
+		fields[name] = value
+	default:
+		return typeConflict(measurement, tags.String(), name, v, value)
+	}
+	return nil
+}
+
+func (set ItemSet) SetBool(measurement string, tags *Tags, name string, value bool) error {
+	fields := set.getFields(measurement, tags)
+	switch v := fields[name].(type) {
+	case nil, bool:
+		fields[name] = value
+	default:
+		return typeConflict(measurement, tags.String(), name, v, value)
+	}
+	return nil
+}
+
+func (set ItemSet) SetString(measurement string, tags *Tags, name string, value string) error {
+	fields := set.getFields(measurement, tags)
+	switch v := fields[name].(type) {
+	case nil, string:
 		fields[name] = value
+	default:
+		return typeConflict(measurement, tags.String(), name, v, value)
 	}
+	return nil
 }
 
-func (set ItemSet) SetFloat(measurement string, tags *Tags, name string, value float64) {
-	set.getFields(measurement, tags)[name] = value
+func (set ItemSet) GetFloat(measurement string, tags *Tags, name string) (float64, error) {
+	switch v := set.getFields(measurement, tags)[name].(type) {
+	case nil:
+		return 0, nil
+	case float64:
+		return v, nil
+	default:
+		return 0, typeConflict(measurement, tags.String(), name, v, float64(0))
+	}
 }
 
-func (set ItemSet) SetInt(measurement string, tags *Tags, name string, value int64) {
-	set.getFields(measurement, tags)[name] = value
+func (set ItemSet) GetInt(measurement string, tags *Tags, name string) (int64, error) {
+	switch v := set.getFields(measurement, tags)[name].(type) {
+	case nil:
+		return 0, nil
+	case int64:
+		return v, nil
+	default:
+		return 0, typeConflict(measurement, tags.String(), name, v, int64(0))
+	}
 }
 
-func (set ItemSet) GetFloat(measurement string, tags *Tags, name string) float64 {
-	if v := set.getFields(measurement, tags)[name]; v == nil {
-		return 0
-	} else {
-		return v.(float64)
+func (set ItemSet) GetBool(measurement string, tags *Tags, name string) (bool, error) {
+	switch v := set.getFields(measurement, tags)[name].(type) {
+	case nil:
+		return false, nil
+	case bool:
+		return v, nil
+	default:
+		return false, typeConflict(measurement, tags.String(), name, v, false)
 	}
 }
 
-func (set ItemSet) GetInt(measurement string, tags *Tags, name string) int64 {
-	if v := set.getFields(measurement, tags)[name]; v == nil {
-		return 0
-	} else {
-		return v.(int64)
+func (set ItemSet) GetString(measurement string, tags *Tags, name string) (string, error) {
+	switch v := set.getFields(measurement, tags)[name].(type) {
+	case nil:
+		return "", nil
+	case string:
+		return v, nil
+	default:
+		return "", typeConflict(measurement, tags.String(), name, v, "")
 	}
 }
+
+func typeConflict(measurement, tags, name string, old, value interface{}) error {
+	return fmt.Errorf("统计字段类型冲突: measurement=%q, tags=%q, name=%q, old=%T, new=%T",
+		measurement, tags, name, old, value)
+}
@@ -0,0 +1,100 @@
+package stats
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestMerge(t *testing.T) {
+	tags := NewTags("host", "a")
+	for _, test := range []struct {
+		name     string
+		old      interface{}
+		value    interface{}
+		set      bool
+		want     interface{}
+		conflict bool
+	}{
+		{"new", nil, int64(1), false, int64(1), false},
+		{"add int", int64(1), int64(2), false, int64(3), false},
+		{"add float", 1.5, 2.0, false, 3.5, false},
+		{"set int", int64(1), int64(2), true, int64(2), false},
+		{"set float", 1.5, 2.0, true, 2.0, false},
+		{"bool", true, false, true, false, false},
+		{"string", "a", "b", true, "b", false},
+		{"int to float", int64(1), 2.0, false, int64(1), true},
+		{"float to string", 1.5, "b", true, 1.5, true},
+		{"bool to int", true, int64(1), false, true, true},
+	} {
+		measurement := "merge_" + strings.Replace(test.name, " ", "_", -1)
+		set, items := make(ItemSet), make(ItemSet)
+		kinds, itemKinds := make(fieldKinds), make(fieldKinds)
+		kind := kindAdd
+		if test.set {
+			kind = kindSet
+		}
+		if test.old != nil {
+			set.getFields(measurement, tags)["value"] = test.old
+			kinds[fieldKey(measurement, "value")] = kind
+		}
+		items.getFields(measurement, tags)["value"] = test.value
+		itemKinds[fieldKey(measurement, "value")] = kind
+		err := set.merge(kinds, items, itemKinds)
+		if (err != nil) != test.conflict {
+			t.Errorf("%s: merge error = %v; want conflict %v", test.name, err, test.conflict)
+		}
+		if got := set[measurement][tags.String()]["value"]; !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: value = %#v; want %#v", test.name, got, test.want)
+		}
+	}
+}
+
+func TestItemSetConflict(t *testing.T) {
+	tags := NewTags("host", "a")
+	set := make(ItemSet)
+	if err := set.AddInt("conflict", tags, "count", 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := set.AddFloat("conflict", tags, "count", 1); err == nil {
+		t.Error("AddFloat on an int field succeeded")
+	}
+	if err := set.SetString("conflict", tags, "name", "a"); err != nil {
+		t.Fatal(err)
+	}
+	for name, err := range map[string]error{
+		"SetFloat":  set.SetFloat("conflict", tags, "name", 1),
+		"SetInt":    set.SetInt("conflict", tags, "name", 1),
+		"SetBool":   set.SetBool("conflict", tags, "name", true),
+		"SetString": set.SetString("conflict", tags, "name", "b"),
+	} {
+		if (err == nil) != (name == "SetString") {
+			t.Errorf("%s error = %v", name, err)
+		}
+	}
+	if value, _ := set.GetString("conflict", tags, "name"); value != "b" {
+		t.Errorf("name = %q; want %q", value, "b")
+	}
+}
+
+func TestKindConflict(t *testing.T) {
+	tags := NewTags("host", "a")
+	transaction := newTestBuffer(nil).NewTransaction()
+	if err := transaction.AddInt("kind", tags, "count", 1); err != nil {
+		t.Fatal(err)
+	}
+	// 同一字段不能既累加又覆盖
+	if err := transaction.SetInt("kind", tags, "count", 1); err == nil {
+		t.Error("SetInt on an added field succeeded")
+	}
+	if err := transaction.SetString("kind", tags, "name", "a"); err != nil {
+		t.Fatal(err)
+	}
+	if err := transaction.AddInt("kind", NewTags("host", "b"), "name", 1); err == nil {
+		t.Error("AddInt on a set field succeeded")
+	}
+	// 聚合方式只属于各自的Buffer
+	if err := newTestBuffer(nil).SetInt("kind", tags, "count", 1); err != nil {
+		t.Errorf("SetInt on another buffer: %v", err)
+	}
+}
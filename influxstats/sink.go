@@ -0,0 +1,123 @@
+package stats
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+
+	"github.com/influxdata/influxdb/client/v2"
+	"github.com/yangchenxing/foochow/logging"
+	"github.com/yangchenxing/foochow/structs"
+)
+
+func init() {
+	// 注册Sink和Buffer工厂
+	structs.RegisterFactory(&SinkFactory{})
+	structs.RegisterFactory(&BufferFactory{})
+}
+
+// Sink 接收Buffer每个周期生成的数据点
+type Sink interface {
+	Write(client.BatchPoints) error
+}
+
+type HTTPSink struct {
+	client.HTTPConfig
+	conn client.Client
+}
+
+func (sink *HTTPSink) initialize() error {
+	var err error
+	if sink.conn, err = client.NewHTTPClient(sink.HTTPConfig); err != nil {
+		return fmt.Errorf("创建InfluxDB客户端出错: %s", err.Error())
+	}
+	return nil
+}
+
+func (sink *HTTPSink) Write(points client.BatchPoints) error {
+	return sink.conn.Write(points)
+}
+
+type UDPSink struct {
+	client.UDPConfig
+	conn client.Client
+}
+
+func (sink *UDPSink) initialize() error {
+	var err error
+	if sink.conn, err = client.NewUDPClient(sink.UDPConfig); err != nil {
+		return fmt.Errorf("创建InfluxDB UDP客户端出错: %s", err.Error())
+	}
+	return nil
+}
+
+func (sink *UDPSink) Write(points client.BatchPoints) error {
+	return sink.conn.Write(points)
+}
+
+// WriterSink 以line protocol格式输出数据点，用于调试
+type WriterSink struct {
+	Writer io.Writer
+}
+
+func (sink *WriterSink) Write(points client.BatchPoints) error {
+	for _, point := range points.Points() {
+		if _, err := fmt.Fprintln(sink.Writer, point.String()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type SinkFactory struct{}
+
+func (factory *SinkFactory) GetInstanceType() reflect.Type {
+	return reflect.TypeOf((*Sink)(nil)).Elem()
+}
+
+func (factory *SinkFactory) Create(data map[string]interface{}) (interface{}, error) {
+	if typeName, ok := data["type"].(string); ok {
+		switch typeName {
+		case "stderr":
+			return &WriterSink{Writer: os.Stderr}, nil
+		case "http":
+			sink := new(HTTPSink)
+			if err := structs.UnmarshalMap(sink, data); err != nil {
+				return nil, err
+			} else if err := sink.initialize(); err != nil {
+				return nil, err
+			}
+			return sink, nil
+		case "udp":
+			sink := new(UDPSink)
+			if err := structs.UnmarshalMap(sink, data); err != nil {
+				return nil, err
+			} else if err := sink.initialize(); err != nil {
+				return nil, err
+			}
+			return sink, nil
+		default:
+			return nil, fmt.Errorf("未知统计Sink类型: %q", typeName)
+		}
+	}
+	return nil, errors.New("缺少\"type\"字段")
+}
+
+// BufferFactory 根据配置创建并启动Buffer，写入出错时记录错误日志
+type BufferFactory struct{}
+
+func (factory *BufferFactory) GetInstanceType() reflect.Type {
+	return reflect.TypeOf((*Buffer)(nil))
+}
+
+func (factory *BufferFactory) Create(data map[string]interface{}) (interface{}, error) {
+	var config Config
+	if err := structs.UnmarshalMap(&config, data); err != nil {
+		return nil, err
+	}
+	return NewBuffer(config, func(err error) {
+		logging.Error("统计数据出错: %s", err.Error())
+	}, nil)
+}
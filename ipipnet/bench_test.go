@@ -0,0 +1,240 @@
+package ipipnet
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math/rand"
+	"net"
+	"net/netip"
+	"sort"
+	"sync"
+	"testing"
+)
+
+// syntheticData 生成n条随机长度的记录覆盖1.0.0.0-223.255.255.255的.dat数据
+func syntheticData(tb testing.TB, n int) []byte {
+	texts := []string{
+		"中国\t广东\t广州\t\t电信",
+		"中国\t广东\t\t\t",
+		"中国\t\t\t\t",
+		"保留地址\t保留地址\t\t",
+	}
+	lower, upper := uint32(1)<<24, uint32(224)<<24
+	random := rand.New(rand.NewSource(1))
+	cuts := make(map[uint32]bool, n)
+	for len(cuts) < n-1 {
+		if cut := lower + 1 + random.Uint32()%(upper-lower-1); !cuts[cut] {
+			cuts[cut] = true
+		}
+	}
+	starts := []uint32{lower}
+	for cut := range cuts {
+		starts = append(starts, cut)
+	}
+	sort.Slice(starts, func(i, j int) bool {
+		return starts[i] < starts[j]
+	})
+	entries := make([]DataEntry, n)
+	for i, start := range starts {
+		end := upper - 1
+		if i+1 < n {
+			end = starts[i+1] - 1
+		}
+		entries[i] = DataEntry{
+			Start: uint32ToIP(start),
+			End:   uint32ToIP(end),
+			Text:  texts[i%len(texts)],
+		}
+	}
+	var buffer bytes.Buffer
+	if err := WriteData(&buffer, entries); err != nil {
+		tb.Fatal(err)
+	}
+	return buffer.Bytes()
+}
+
+func uint32ToIP(v uint32) net.IP {
+	ip := make(net.IP, net.IPv4len)
+	binary.BigEndian.PutUint32(ip, v)
+	return ip
+}
+
+func randomAddrs(n int) []netip.Addr {
+	random := rand.New(rand.NewSource(1))
+	addrs := make([]netip.Addr, n)
+	for i := range addrs {
+		var a [4]byte
+		binary.BigEndian.PutUint32(a[:], random.Uint32())
+		addrs[i] = netip.AddrFrom4(a)
+	}
+	return addrs
+}
+
+func TestLocateAllocs(t *testing.T) {
+	locator, err := newTestLocator(t, syntheticData(t, 10000), "ipipnet.dat", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	addrs := randomAddrs(256)
+	results := make([]*Location, len(addrs))
+	allocs := testing.AllocsPerRun(100, func() {
+		for _, addr := range addrs {
+			locator.LocateAddr(addr)
+			a := addr.As4()
+			locator.LocateUint32(binary.BigEndian.Uint32(a[:]))
+		}
+		locator.LocateBatch(addrs, results)
+	})
+	if allocs != 0 {
+		t.Errorf("allocs per run = %v; want 0", allocs)
+	}
+}
+
+func TestLocateBatch(t *testing.T) {
+	locator, err := newTestLocator(t, syntheticData(t, 10000), "ipipnet.dat", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	addrs := append(randomAddrs(100), netip.Addr{}, netip.MustParseAddr("2001::1"))
+	results := make([]*Location, len(addrs))
+	if err := locator.LocateBatch(addrs, results); err != nil {
+		t.Fatal(err)
+	}
+	for i, addr := range addrs[:100] {
+		want, err := locator.Locate(net.IP(addr.AsSlice()))
+		if err != nil || results[i] != want {
+			t.Errorf("LocateBatch[%d] = %v; want %v, %v", i, results[i], want, err)
+		}
+	}
+	if results[100] != nil || results[101] != nil {
+		t.Errorf("invalid addresses got %v, %v; want nil", results[100], results[101])
+	}
+	if err := locator.LocateBatch(addrs, results[:1]); err == nil {
+		t.Error("LocateBatch succeeded with short results")
+	}
+}
+
+func BenchmarkLocate(b *testing.B) {
+	locator, err := newTestLocator(b, syntheticData(b, 500000), "ipipnet.dat", nil)
+	if err != nil {
+		b.Fatal(err)
+	}
+	addrs := randomAddrs(4096)
+	ips := make([]net.IP, len(addrs))
+	for i, addr := range addrs {
+		ips[i] = net.IP(addr.AsSlice())
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		locator.Locate(ips[i&4095])
+	}
+}
+
+func BenchmarkLocateAddr(b *testing.B) {
+	locator, err := newTestLocator(b, syntheticData(b, 500000), "ipipnet.dat", nil)
+	if err != nil {
+		b.Fatal(err)
+	}
+	addrs := randomAddrs(4096)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		locator.LocateAddr(addrs[i&4095])
+	}
+}
+
+func BenchmarkLocateUint32(b *testing.B) {
+	locator, err := newTestLocator(b, syntheticData(b, 500000), "ipipnet.dat", nil)
+	if err != nil {
+		b.Fatal(err)
+	}
+	addrs := randomAddrs(4096)
+	values := make([]uint32, len(addrs))
+	for i, addr := range addrs {
+		values[i] = binary.BigEndian.Uint32(addr.AsSlice())
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		locator.LocateUint32(values[i&4095])
+	}
+}
+
+func BenchmarkLocateBatch(b *testing.B) {
+	locator, err := newTestLocator(b, syntheticData(b, 500000), "ipipnet.dat", nil)
+	if err != nil {
+		b.Fatal(err)
+	}
+	addrs := randomAddrs(4096)
+	results := make([]*Location, len(addrs))
+	b.ReportAllocs()
+	b.ResetTimer()
+	// 每次循环查询len(addrs)个地址，ns/op为单个地址的耗时
+	for i := 0; i < b.N; i += len(addrs) {
+		locator.LocateBatch(addrs, results)
+	}
+}
+
+func TestIndex16(t *testing.T) {
+	index, err := parseData(syntheticData(t, 100000), newLocationBuilder(newIDTable(), nil, nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 加入空隙
+	index.sections = append(index.sections[:50000:50000], index.sections[50010:]...)
+	index.buildIndex()
+	dense := *index
+	dense.buildIndex16()
+	random := rand.New(rand.NewSource(1))
+	check := func(v uint32) {
+		if got, want := dense.locate(v), index.locate(v); got != want {
+			t.Fatalf("prefix16 locate(%08x) = %p; want %p", v, got, want)
+		}
+	}
+	for i := 0; i < 100000; i++ {
+		check(random.Uint32())
+	}
+	for _, section := range index.sections[49990:50010] {
+		check(section.lower)
+		check(section.upper)
+		check(section.upper + 1)
+	}
+	check(0)
+	check(0xFFFFFFFF)
+}
+
+var (
+	fullData     []byte
+	fullDataOnce sync.Once
+)
+
+// BenchmarkIndex 在200万条记录的数据上比较两种查找范围的速度和内存，index-bytes为区段和查找范围占用的字节数
+func BenchmarkIndex(b *testing.B) {
+	fullDataOnce.Do(func() {
+		fullData = syntheticData(b, 2000000)
+	})
+	addrs := randomAddrs(4096)
+	values := make([]uint32, len(addrs))
+	for i, addr := range addrs {
+		a := addr.As4()
+		values[i] = binary.BigEndian.Uint32(a[:])
+	}
+	for _, indexType := range []string{"prefix8", "prefix16"} {
+		b.Run(indexType, func(b *testing.B) {
+			index, err := parseData(fullData, newLocationBuilder(newIDTable(), nil, nil))
+			if err != nil {
+				b.Fatal(err)
+			}
+			if indexType == "prefix16" {
+				index.buildIndex16()
+			}
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				index.locate(values[i&4095])
+			}
+			b.ReportMetric(float64(index.indexSize()), "index-bytes")
+		})
+	}
+}
@@ -0,0 +1,139 @@
+package ipipnet
+
+import (
+	"crypto/sha1"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+)
+
+const (
+	// 文件头4字节，之后是256个首字节索引
+	datHeaderSize = 4 + 1024
+	datRecordSize = 8
+)
+
+// parse 根据文件内容选择数据格式
+func parse(data []byte, builder *locationBuilder, language string) (*ipIndex, error) {
+	if isMMDBData(data) {
+		return parseMMDB(data, builder, language)
+	} else if isIPDBData(data) {
+		return parseIPDB(data, builder, language)
+	} else if isTextData(data) {
+		return parseText(data, builder)
+	}
+	return parseData(data, builder)
+}
+
+// parseData 解析.dat格式：文件头是索引区结束位置加1024，
+// 索引记录为4字节大端结束IP、3字节小端文本偏移和1字节文本长度，文本偏移从索引区结束位置起算
+func parseData(data []byte, builder *locationBuilder) (*ipIndex, error) {
+	if len(data) < datHeaderSize {
+		return nil, fmt.Errorf("数据文件过短: size=%d", len(data))
+	}
+	header := binary.BigEndian.Uint32(data[:4])
+	if header < datHeaderSize+1024 || uint64(header)-1024 > uint64(len(data)) {
+		return nil, fmt.Errorf("文件头偏移越界: header=%d, size=%d", header, len(data))
+	}
+	textOffset := header - 1024
+	if (textOffset-datHeaderSize)%datRecordSize != 0 {
+		return nil, fmt.Errorf("索引区长度不是%d的整数倍: length=%d", datRecordSize, textOffset-datHeaderSize)
+	}
+	newIndex := &ipIndex{
+		sections: make([]section, (textOffset-datHeaderSize)/datRecordSize),
+	}
+	startIP := uint32(1)
+	for i, offset := 0, uint32(datHeaderSize); offset < textOffset; i, offset = i+1, offset+datRecordSize {
+		if i > 0 && startIP == 0 {
+			return nil, fmt.Errorf("索引记录超出地址空间: record=%d", i)
+		}
+		endIP := binary.BigEndian.Uint32(data[offset : offset+4])
+		if endIP < startIP {
+			return nil, fmt.Errorf("索引记录未按地址递增: record=%d, start=%d, end=%d", i, startIP, endIP)
+		}
+		newIndex.sections[i].lower = startIP
+		newIndex.sections[i].upper = endIP
+		dataOffset := uint64(textOffset) + uint64(uint32(data[offset+4])|uint32(data[offset+5])<<8|uint32(data[offset+6])<<16)
+		dataLength := uint64(data[offset+7])
+		if dataOffset+dataLength > uint64(len(data)) {
+			return nil, fmt.Errorf("文本偏移越界: record=%d, offset=%d, length=%d, size=%d",
+				i, dataOffset, dataLength, len(data))
+		}
+		newIndex.sections[i].Location = builder.getLocation(data[dataOffset : dataOffset+dataLength])
+		startIP = endIP + 1
+	}
+	newIndex.buildIndex()
+	newIndex.checksum = fmt.Sprintf("%x", sha1.Sum(data))
+	newIndex.format = "dat"
+	return newIndex, nil
+}
+
+// DataEntry 是.dat文件中的一条记录，Text为Tab分隔的地点和ISP文本
+type DataEntry struct {
+	Start net.IP
+	End   net.IP
+	Text  string
+}
+
+// WriteData 把按地址递增且互不重叠的IPv4记录写成.dat格式。
+// 记录间的空隙以空文本补齐，相同的文本只写一次。
+// 文件头中的首字节索引为每个首字节对应的第一条记录序号（4字节小端）
+func WriteData(w io.Writer, entries []DataEntry) error {
+	type record struct {
+		end  uint32
+		text string
+	}
+	records := make([]record, 0, len(entries))
+	next, first := uint32(0), true
+	for i, entry := range entries {
+		start4, end4 := entry.Start.To4(), entry.End.To4()
+		if start4 == nil || end4 == nil {
+			return fmt.Errorf("非IPv4记录: record=%d, start=%s, end=%s", i, entry.Start, entry.End)
+		}
+		start, end := ipToUint32(start4), ipToUint32(end4)
+		if end < start || (!first && start < next) || (!first && next == 0) {
+			return fmt.Errorf("记录未按地址递增或有重叠: record=%d, start=%s, end=%s", i, entry.Start, entry.End)
+		}
+		if len(entry.Text) > 255 {
+			return fmt.Errorf("记录文本过长: record=%d, length=%d", i, len(entry.Text))
+		}
+		// 文件中第一条记录从1开始
+		if start > next && !(next == 0 && start == 1) {
+			records = append(records, record{end: start - 1})
+		}
+		records = append(records, record{end: end, text: entry.Text})
+		next, first = end+1, false
+	}
+	textOffset := datHeaderSize + datRecordSize*len(records)
+	data := make([]byte, textOffset)
+	binary.BigEndian.PutUint32(data, uint32(textOffset+1024))
+	for b, i := 0, 0; b < 256; b++ {
+		for i < len(records) && records[i].end>>24 < uint32(b) {
+			i++
+		}
+		binary.LittleEndian.PutUint32(data[4+4*b:], uint32(i))
+	}
+	offsets := make(map[string]int)
+	var texts []byte
+	for i, record := range records {
+		offset, found := offsets[record.text]
+		if !found {
+			offset = len(texts)
+			if offset+len(record.text) >= 1<<24 {
+				return fmt.Errorf("文本区超过16MB: record=%d", i)
+			}
+			offsets[record.text] = offset
+			texts = append(texts, record.text...)
+		}
+		item := data[datHeaderSize+datRecordSize*i:]
+		binary.BigEndian.PutUint32(item, record.end)
+		item[4], item[5], item[6] = byte(offset), byte(offset>>8), byte(offset>>16)
+		item[7] = byte(len(record.text))
+	}
+	if _, err := w.Write(data); err != nil {
+		return err
+	}
+	_, err := w.Write(texts)
+	return err
+}
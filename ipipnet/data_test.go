@@ -0,0 +1,113 @@
+package ipipnet
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"testing"
+)
+
+const testIDs = "region,中国,1\nregion,中国,广东,2\nregion,中国,广东,广州,3\nisp,电信,10\n"
+
+// testData 生成三条记录的.dat数据
+func testData() []byte {
+	var buffer bytes.Buffer
+	err := WriteData(&buffer, []DataEntry{
+		{net.IPv4(0, 0, 0, 0), net.IPv4(0, 255, 255, 255), "保留地址\t保留地址\t\t"},
+		{net.IPv4(1, 0, 0, 0), net.IPv4(1, 255, 255, 255), "中国\t广东\t广州\t\t电信"},
+		{net.IPv4(2, 0, 0, 0), net.IPv4(255, 255, 255, 255), "中国\t\t\t\t"},
+	})
+	if err != nil {
+		panic(err)
+	}
+	return buffer.Bytes()
+}
+
+func TestParseDataTruncated(t *testing.T) {
+	builder, data := newLocationBuilder(newIDTable(), nil, nil), testData()
+	if _, err := parseData(data, builder); err != nil {
+		t.Fatalf("parseData error = %v", err)
+	}
+	for size := 0; size < len(data); size++ {
+		if _, err := parseData(data[:size], builder); err == nil {
+			t.Errorf("parseData(data[:%d]) succeeded; want error", size)
+		}
+	}
+}
+
+func FuzzParseData(f *testing.F) {
+	f.Add(testData())
+	f.Add(testData()[:1060])
+	f.Fuzz(func(t *testing.T, data []byte) {
+		parseData(data, newLocationBuilder(newIDTable(), nil, nil))
+	})
+}
+
+func FuzzParse(f *testing.F) {
+	f.Add(testData())
+	f.Add([]byte("1.0.0.0\t1.255.255.255\t中国\t广东\t广州\t电信\n"))
+	f.Add([]byte("\x00\x00\x00\x02{}"))
+	f.Add(append([]byte("\x00\x00\x00\x00"), mmdbMetadataMarker...))
+	f.Fuzz(func(t *testing.T, data []byte) {
+		parse(data, newLocationBuilder(newIDTable(), nil, nil), "")
+	})
+}
+
+func TestWriteData(t *testing.T) {
+	var buffer bytes.Buffer
+	err := WriteData(&buffer, []DataEntry{
+		{net.IPv4(1, 0, 0, 0), net.IPv4(1, 0, 0, 255), "中国\t广东\t广州\t\t电信"},
+		{net.IPv4(1, 0, 2, 0), net.IPv4(1, 0, 2, 255), "中国\t广东\t广州\t\t电信"},
+		{net.IPv4(3, 0, 0, 0), net.IPv4(3, 0, 0, 0), "中国\t\t\t\t"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := buffer.Bytes()
+	locator, err := newTestLocator(t, data, "ipipnet.dat", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 记录之间的空隙以空文本补齐
+	if sections := locator.Metadata().Sections; sections != 6 {
+		t.Errorf("sections = %d; want 6", sections)
+	}
+	for ip, want := range map[string]string{
+		"0.0.0.1":   "",
+		"1.0.0.1":   "中国(1)/广东(2)/广州(3)/电信(10)",
+		"1.0.1.1":   "",
+		"1.0.2.255": "中国(1)/广东(2)/广州(3)/电信(10)",
+		"2.0.0.1":   "",
+		"3.0.0.0":   "中国(1)",
+		"3.0.0.1":   "<nil>",
+	} {
+		location, err := locator.Locate(net.ParseIP(ip))
+		if got := fmt.Sprint(location); err != nil || got != want {
+			t.Errorf("Locate(%s) = %q, %v; want %q", ip, got, err, want)
+		}
+	}
+	// 相同文本只写一次
+	if text := data[binary.BigEndian.Uint32(data)-1024:]; string(text) != "中国\t广东\t广州\t\t电信中国\t\t\t\t" {
+		t.Errorf("text area = %q", text)
+	}
+	// 首字节索引指向可能包含该首字节地址的第一条记录
+	for b, want := range map[int]uint32{0: 0, 1: 1, 2: 4, 3: 5, 4: 6, 255: 6} {
+		if got := binary.LittleEndian.Uint32(data[4+4*b:]); got != want {
+			t.Errorf("index[%d] = %d; want %d", b, got, want)
+		}
+	}
+}
+
+func TestWriteDataInvalid(t *testing.T) {
+	for _, entries := range [][]DataEntry{
+		{{net.IPv4(1, 0, 0, 1), net.IPv4(1, 0, 0, 0), ""}},
+		{{net.IPv4(1, 0, 0, 0), net.IPv4(1, 0, 0, 9), ""}, {net.IPv4(1, 0, 0, 5), net.IPv4(1, 0, 0, 19), ""}},
+		{{net.ParseIP("2001::"), net.ParseIP("2001::1"), ""}},
+		{{net.IPv4(1, 0, 0, 0), net.IPv4(1, 0, 0, 9), string(make([]byte, 256))}},
+	} {
+		if err := WriteData(new(bytes.Buffer), entries); err == nil {
+			t.Errorf("WriteData(%v) succeeded; want error", entries)
+		}
+	}
+}
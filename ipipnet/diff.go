@@ -0,0 +1,253 @@
+package ipipnet
+
+import (
+	"math"
+	"net"
+	"sort"
+)
+
+// Change 描述位置发生变化的一段地址
+type Change struct {
+	Start     net.IP    `json:"start"`
+	End       net.IP    `json:"end"`
+	Old       *Location `json:"old"`
+	New       *Location `json:"new"`
+	Addresses uint64    `json:"addresses"` // IPv6超出范围时为math.MaxUint64
+}
+
+// DiffGroup 统计一个国家、省份或ISP迁入迁出的IPv4地址数
+type DiffGroup struct {
+	Name    string `json:"name"`
+	Removed uint64 `json:"removed"`
+	Added   uint64 `json:"added"`
+}
+
+// Diff 是两份数据的比较结果，统计只计算IPv4地址
+type Diff struct {
+	Changes   []Change    `json:"changes"`
+	Addresses uint64      `json:"addresses"`
+	Ratio     float64     `json:"ratio"` // 变化的地址数占旧数据覆盖地址数的比例
+	Countries []DiffGroup `json:"countries"`
+	Provinces []DiffGroup `json:"provinces"`
+	ISPs      []DiffGroup `json:"isps"`
+}
+
+// Compare 使用同一份ID文件分别加载两个数据文件并比较
+func Compare(config Config, oldPath, newPath string) (*Diff, error) {
+	oldData, err := loadDataset(config, oldPath)
+	if err != nil {
+		return nil, err
+	}
+	newData, err := loadDataset(config, newPath)
+	if err != nil {
+		return nil, err
+	}
+	return diffIndex(oldData.index, newData.index), nil
+}
+
+// Diff 比较当前数据和指定的数据文件
+func (locator *Locator) Diff(path string) (*Diff, error) {
+	newData, err := loadDataset(locator.config, path)
+	if err != nil {
+		return nil, err
+	}
+	return diffIndex(locator.data.Load().index, newData.index), nil
+}
+
+// span 是统一为128位表示的区段
+type span struct {
+	lower    uint128
+	upper    uint128
+	location *Location
+}
+
+type spanCursor struct {
+	spans []span
+	i     int
+}
+
+// at 返回pos所在区段的位置，以及位置不变的最后一个地址
+func (cursor *spanCursor) at(pos, max uint128) (*Location, uint128) {
+	for cursor.i < len(cursor.spans) && cursor.spans[cursor.i].upper.less(pos) {
+		cursor.i++
+	}
+	if cursor.i == len(cursor.spans) {
+		return nil, max
+	}
+	s := cursor.spans[cursor.i]
+	if pos.less(s.lower) {
+		return nil, s.lower.sub1()
+	}
+	return s.location, s.upper
+}
+
+func spans4(sections []section) []span {
+	spans := make([]span, len(sections))
+	for i, section := range sections {
+		spans[i] = span{uint128{lo: uint64(section.lower)}, uint128{lo: uint64(section.upper)}, section.Location}
+	}
+	return spans
+}
+
+func spans6(sections []section6) []span {
+	spans := make([]span, len(sections))
+	for i, section := range sections {
+		spans[i] = span{section.lower, section.upper, section.Location}
+	}
+	return spans
+}
+
+func diffIndex(oldIndex, newIndex *ipIndex) *Diff {
+	diff := &Diff{}
+	diff.Changes = diffSpans(diff.Changes, spans4(oldIndex.sections), spans4(newIndex.sections), uint128{lo: math.MaxUint32}, 32)
+	n := len(diff.Changes)
+	diff.Changes = diffSpans(diff.Changes, spans6(oldIndex.sections6), spans6(newIndex.sections6), uint128{math.MaxUint64, math.MaxUint64}, 128)
+	countries := make(map[string]*DiffGroup)
+	provinces := make(map[string]*DiffGroup)
+	isps := make(map[string]*DiffGroup)
+	for _, change := range diff.Changes[:n] {
+		diff.Addresses += change.Addresses
+		countGroups(countries, countryGroups(change.Old), countryGroups(change.New), change.Addresses)
+		countGroups(provinces, provinceGroups(change.Old), provinceGroups(change.New), change.Addresses)
+		countGroups(isps, change.Old.GetISPNames(), change.New.GetISPNames(), change.Addresses)
+	}
+	var covered uint64
+	for _, section := range oldIndex.sections {
+		covered += uint64(section.upper-section.lower) + 1
+	}
+	if covered == 0 {
+		covered = 1 << 32
+	}
+	diff.Ratio = float64(diff.Addresses) / float64(covered)
+	diff.Countries = sortGroups(countries)
+	diff.Provinces = sortGroups(provinces)
+	diff.ISPs = sortGroups(isps)
+	return diff
+}
+
+// diffSpans 同步遍历两组有序区段，把位置不同的地址段追加到changes
+func diffSpans(changes []Change, oldSpans, newSpans []span, max uint128, size int) []Change {
+	oldCursor, newCursor := &spanCursor{spans: oldSpans}, &spanCursor{spans: newSpans}
+	start := len(changes)
+	var pos, lastEnd uint128
+	for {
+		oldLocation, oldEnd := oldCursor.at(pos, max)
+		newLocation, newEnd := newCursor.at(pos, max)
+		end := oldEnd
+		if newEnd.less(end) {
+			end = newEnd
+		}
+		if oldKey, newKey := locationKey(oldLocation), locationKey(newLocation); oldKey != newKey {
+			last := len(changes) - 1
+			if last >= start && locationKey(changes[last].Old) == oldKey && locationKey(changes[last].New) == newKey &&
+				lastEnd.add1() == pos {
+				changes[last].End = end.toIP(size)
+				changes[last].Addresses = addSaturated(changes[last].Addresses, count(pos, end))
+			} else {
+				changes = append(changes, Change{
+					Start:     pos.toIP(size),
+					End:       end.toIP(size),
+					Old:       oldLocation,
+					New:       newLocation,
+					Addresses: count(pos, end),
+				})
+			}
+			lastEnd = end
+		}
+		if end == max {
+			return changes
+		}
+		pos = end.add1()
+	}
+}
+
+func locationKey(location *Location) string {
+	if location == nil {
+		return ""
+	}
+	return location.String()
+}
+
+func countryGroups(location *Location) []string {
+	if name := location.GetCountryName(); name != "" {
+		return []string{name}
+	}
+	return nil
+}
+
+func provinceGroups(location *Location) []string {
+	if name := location.GetProvinceName(); name != "" {
+		return []string{location.GetCountryName() + "/" + name}
+	}
+	return nil
+}
+
+// countGroups 把地址数计入离开的旧分组和进入的新分组
+func countGroups(groups map[string]*DiffGroup, oldNames, newNames []string, addresses uint64) {
+	group := func(name string) *DiffGroup {
+		if groups[name] == nil {
+			groups[name] = &DiffGroup{Name: name}
+		}
+		return groups[name]
+	}
+	for _, name := range oldNames {
+		if !containsString(newNames, name) {
+			group(name).Removed += addresses
+		}
+	}
+	for _, name := range newNames {
+		if !containsString(oldNames, name) {
+			group(name).Added += addresses
+		}
+	}
+}
+
+func containsString(items []string, item string) bool {
+	for _, i := range items {
+		if i == item {
+			return true
+		}
+	}
+	return false
+}
+
+// sortGroups 按变化量从大到小排序
+func sortGroups(groups map[string]*DiffGroup) []DiffGroup {
+	result := make([]DiffGroup, 0, len(groups))
+	for _, group := range groups {
+		result = append(result, *group)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if a, b := result[i].Removed+result[i].Added, result[j].Removed+result[j].Added; a != b {
+			return a > b
+		}
+		return result[i].Name < result[j].Name
+	})
+	return result
+}
+
+func count(lower, upper uint128) uint64 {
+	hi, lo := upper.hi-lower.hi, upper.lo-lower.lo
+	if upper.lo < lower.lo {
+		hi--
+	}
+	if hi != 0 || lo == math.MaxUint64 {
+		return math.MaxUint64
+	}
+	return lo + 1
+}
+
+func addSaturated(a, b uint64) uint64 {
+	if a+b < a {
+		return math.MaxUint64
+	}
+	return a + b
+}
+
+func (v uint128) sub1() uint128 {
+	if v.lo == 0 {
+		v.hi--
+	}
+	v.lo--
+	return v
+}
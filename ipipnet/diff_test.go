@@ -0,0 +1,100 @@
+package ipipnet
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const (
+	testOldText = "1.0.0.0\t1.0.0.191\t中国\t广东\t广州\t\t电信\n" +
+		"1.0.0.192\t1.0.0.255\t中国\t广东\t广州\t\t电信\n" +
+		"1.0.1.0\t1.0.1.255\t中国\t广东\t\t\t\n"
+	testNewText = "1.0.0.0\t1.0.0.127\t中国\t广东\t广州\t\t电信\n" +
+		"1.0.0.128\t1.0.1.255\t中国\t广东\t\t\t\n" +
+		"2.0.0.0\t2.0.0.255\t中国\t\t\t\t\n"
+)
+
+func TestCompare(t *testing.T) {
+	dir := t.TempDir()
+	config := Config{IDsPath: filepath.Join(dir, "ipipnet.ids")}
+	oldPath, newPath := filepath.Join(dir, "old.txt"), filepath.Join(dir, "new.txt")
+	for path, content := range map[string]string{config.IDsPath: testIDs, oldPath: testOldText, newPath: testNewText} {
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	diff, err := Compare(config, oldPath, newPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(diff.Changes) != 2 {
+		t.Fatalf("Changes = %+v; want 2 changes", diff.Changes)
+	}
+	if c := diff.Changes[0]; c.Start.String() != "1.0.0.128" || c.End.String() != "1.0.0.255" ||
+		c.Old.GetCityID() != 3 || c.New.GetCityID() != 0 || c.Addresses != 128 {
+		t.Errorf("Changes[0] = %+v", c)
+	}
+	if c := diff.Changes[1]; c.Start.String() != "2.0.0.0" || c.End.String() != "2.0.0.255" ||
+		c.Old != nil || c.New.GetCountryID() != 1 || c.Addresses != 256 {
+		t.Errorf("Changes[1] = %+v", c)
+	}
+	if diff.Addresses != 384 || diff.Ratio != 0.75 {
+		t.Errorf("Addresses = %d, Ratio = %f; want 384, 0.75", diff.Addresses, diff.Ratio)
+	}
+	if len(diff.Countries) != 1 || diff.Countries[0] != (DiffGroup{Name: "中国", Added: 256}) {
+		t.Errorf("Countries = %+v", diff.Countries)
+	}
+	if len(diff.Provinces) != 0 {
+		t.Errorf("Provinces = %+v; want none", diff.Provinces)
+	}
+	if len(diff.ISPs) != 1 || diff.ISPs[0] != (DiffGroup{Name: "电信", Removed: 128}) {
+		t.Errorf("ISPs = %+v", diff.ISPs)
+	}
+}
+
+func TestReloadRefusesLargeChange(t *testing.T) {
+	server := newTestServer([]byte(testNewText))
+	defer server.Close()
+	locator, err := newTestLocator(t, []byte(testOldText), "ipipnet.txt", func(config *Config) {
+		config.DataURL = server.URL
+		config.MaxChangeRatio = 0.5
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := locator.config
+	checksum := locator.Metadata().Checksum
+	if err := locator.reload(); err == nil {
+		t.Fatal("reload succeeded; want refusal")
+	}
+	if locator.Metadata().Checksum != checksum {
+		t.Error("refused data was published")
+	}
+	if content, _ := ioutil.ReadFile(config.DataPath); !bytes.Equal(content, []byte(testOldText)) {
+		t.Error("data file replaced by refused data")
+	}
+	if err := locator.reload(); err != ErrDuplicatedDownload {
+		t.Errorf("second reload error = %v; want ErrDuplicatedDownload", err)
+	}
+	// 数据未更新时状态中保留拒绝原因
+	locator.check()
+	if status := locator.Status(); !strings.Contains(status.LastError, "新数据变化过大") {
+		t.Errorf("LastError = %q; want the refusal", status.LastError)
+	}
+	// 服务端数据再次变化且变化量在阈值内时接受
+	newText := testNewText + "3.0.0.0\t3.0.0.255\t中国\t\t\t\t\n"
+	server.data, server.etag = []byte(newText), fmt.Sprintf(`"sha1-%x"`, sha1.Sum([]byte(newText)))
+	locator.config.MaxChangeRatio = 2
+	locator.check()
+	if status := locator.Status(); status.LastError != "" {
+		t.Fatalf("LastError = %q after accepting new data", status.LastError)
+	}
+	if content, _ := ioutil.ReadFile(config.DataPath); !bytes.Equal(content, []byte(newText)) {
+		t.Error("data file not replaced")
+	}
+}
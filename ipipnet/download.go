@@ -0,0 +1,187 @@
+package ipipnet
+
+import (
+	"context"
+	"crypto/sha1"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/yangchenxing/foochow/logging"
+)
+
+const (
+	defaultDownloadTimeout = 10 * time.Minute
+)
+
+// Downloader 下载数据文件。
+// 请求带If-None-Match和If-Modified-Since，未完成的临时文件用Range续传，
+// 下载内容按ETag中的SHA-1校验通过后才替换数据文件
+type Downloader struct {
+	url  string
+	path string
+	// modTimePath 为If-Modified-Since所用的文件，下载到暂存路径时指向当前数据文件
+	modTimePath string
+	client      *http.Client
+}
+
+// NewDownloader 创建下载器，timeout为0时使用默认超时，proxy为空时使用环境变量中的代理
+func NewDownloader(dataURL, path string, timeout time.Duration, proxy string) (*Downloader, error) {
+	if timeout <= 0 {
+		timeout = defaultDownloadTimeout
+	}
+	transport := &http.Transport{
+		Proxy: http.ProxyFromEnvironment,
+	}
+	if proxy != "" {
+		proxyURL, err := url.Parse(proxy)
+		if err != nil {
+			return nil, fmt.Errorf("错误的代理地址: proxy=%q, error=%q", proxy, err.Error())
+		}
+		transport.Proxy = http.ProxyURL(proxyURL)
+	}
+	return &Downloader{
+		url:  dataURL,
+		path: path,
+		client: &http.Client{
+			Transport: transport,
+			Timeout:   timeout,
+		},
+	}, nil
+}
+
+// Download 下载数据文件，checksum为当前数据的SHA-1，数据未更新时返回ErrDuplicatedDownload
+func (downloader *Downloader) Download(ctx context.Context, checksum string) error {
+	tmpPath := downloader.path + ".tmp"
+	etagPath := tmpPath + ".etag"
+	request, err := http.NewRequest("GET", downloader.url, nil)
+	if err != nil {
+		return fmt.Errorf("创建HTTP请求出错: url=%q, error=%q", downloader.url, err.Error())
+	}
+	request = request.WithContext(ctx)
+	if checksum != "" {
+		request.Header.Set("If-None-Match", `"sha1-`+checksum+`"`)
+		modTimePath := downloader.path
+		if downloader.modTimePath != "" {
+			modTimePath = downloader.modTimePath
+		}
+		if info, err := os.Stat(modTimePath); err == nil {
+			request.Header.Set("If-Modified-Since", info.ModTime().UTC().Format(http.TimeFormat))
+		}
+	}
+	// 存在未完成的下载时尝试续传
+	var offset int64
+	if info, err := os.Stat(tmpPath); err == nil && info.Size() > 0 {
+		if etag, err := ioutil.ReadFile(etagPath); err == nil && len(etag) > 0 {
+			offset = info.Size()
+			request.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+			request.Header.Set("If-Range", string(etag))
+		}
+	}
+	response, err := downloader.client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	switch response.StatusCode {
+	case http.StatusNotModified:
+		return ErrDuplicatedDownload
+	case http.StatusOK:
+		offset = 0
+	case http.StatusPartialContent:
+		if start, ok := parseContentRangeStart(response.Header.Get("Content-Range")); !ok || start != offset {
+			return fmt.Errorf("续传范围不符: expected=%d, Content-Range=%q", offset, response.Header.Get("Content-Range"))
+		}
+	default:
+		return fmt.Errorf("HTTP应答状态错误: %s", response.Status)
+	}
+	etag := response.Header.Get("ETag")
+	expected, err := parseETagChecksum(etag)
+	if err != nil {
+		return err
+	}
+	if checksum != "" && checksum == expected {
+		return ErrDuplicatedDownload
+	}
+	hasher := sha1.New()
+	file, err := downloader.openTmp(tmpPath, offset, hasher)
+	if err != nil {
+		return err
+	}
+	if offset == 0 {
+		if err := ioutil.WriteFile(etagPath, []byte(etag), 0644); err != nil {
+			file.Close()
+			return fmt.Errorf("写入ETag文件出错: path=%q, error=%q", etagPath, err.Error())
+		}
+	}
+	if _, err := io.Copy(io.MultiWriter(file, hasher), response.Body); err != nil {
+		file.Close()
+		return fmt.Errorf("下载内容出错: path=%q, error=%q", tmpPath, err.Error())
+	}
+	if err := file.Close(); err != nil {
+		return fmt.Errorf("写入临时文件出错: path=%q, error=%q", tmpPath, err.Error())
+	}
+	if actual := fmt.Sprintf("%x", hasher.Sum(nil)); actual != expected {
+		os.Remove(tmpPath)
+		os.Remove(etagPath)
+		return fmt.Errorf("下载内容校验失败: expected=%s, actual=%s", expected, actual)
+	}
+	if err := os.Rename(tmpPath, downloader.path); err != nil {
+		return fmt.Errorf("重命名临时文件出错: oldpath=%q, newpath=%q, error=%q",
+			tmpPath, downloader.path, err.Error())
+	}
+	os.Remove(etagPath)
+	logging.Debug("下载IPIP.net数据完成: checksum=%s", expected)
+	return nil
+}
+
+// openTmp 打开临时文件，续传时把已下载的内容计入hasher
+func (downloader *Downloader) openTmp(path string, offset int64, hasher hash.Hash) (*os.File, error) {
+	if offset == 0 {
+		file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+		if err != nil {
+			return nil, fmt.Errorf("打开临时文件出错: path=%q, error=%q", path, err.Error())
+		}
+		return file, nil
+	}
+	file, err := os.OpenFile(path, os.O_RDWR, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("打开临时文件出错: path=%q, error=%q", path, err.Error())
+	}
+	if _, err := io.CopyN(hasher, file, offset); err != nil {
+		file.Close()
+		return nil, fmt.Errorf("读取临时文件出错: path=%q, error=%q", path, err.Error())
+	}
+	return file, nil
+}
+
+func parseETagChecksum(etag string) (string, error) {
+	if etag == "" {
+		return "", errors.New("HTTP应答头缺少ETag字段")
+	}
+	text := strings.Trim(strings.TrimPrefix(etag, "W/"), `"`)
+	if !strings.HasPrefix(text, "sha1-") {
+		return "", fmt.Errorf("不支持的ETag: %q", etag)
+	}
+	return strings.ToLower(text[5:]), nil
+}
+
+func parseContentRangeStart(contentRange string) (int64, bool) {
+	if !strings.HasPrefix(contentRange, "bytes ") {
+		return 0, false
+	}
+	text := contentRange[len("bytes "):]
+	if i := strings.IndexByte(text, '-'); i > 0 {
+		start, err := strconv.ParseInt(text[:i], 10, 64)
+		return start, err == nil
+	}
+	return 0, false
+}
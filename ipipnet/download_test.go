@@ -0,0 +1,180 @@
+package ipipnet
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha1"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+type testServer struct {
+	*httptest.Server
+	data     []byte
+	etag     string
+	requests []*http.Request
+}
+
+func newTestServer(data []byte) *testServer {
+	server := &testServer{
+		data: data,
+		etag: fmt.Sprintf(`"sha1-%x"`, sha1.Sum(data)),
+	}
+	server.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		server.requests = append(server.requests, r)
+		w.Header().Set("ETag", server.etag)
+		http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(server.data))
+	}))
+	return server
+}
+
+func newTestDownloader(t *testing.T, server *testServer) *Downloader {
+	downloader, err := NewDownloader(server.URL, filepath.Join(t.TempDir(), "ipipnet.dat"), time.Second, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return downloader
+}
+
+func TestDownload(t *testing.T) {
+	server := newTestServer(testData())
+	defer server.Close()
+	downloader := newTestDownloader(t, server)
+	if err := downloader.Download(context.Background(), ""); err != nil {
+		t.Fatalf("Download error = %v", err)
+	}
+	if data, err := ioutil.ReadFile(downloader.path); err != nil || !bytes.Equal(data, server.data) {
+		t.Errorf("downloaded data mismatch: error=%v", err)
+	}
+	if _, err := os.Stat(downloader.path + ".tmp"); !os.IsNotExist(err) {
+		t.Errorf("temporary file left behind: %v", err)
+	}
+}
+
+func TestDownloadNotModified(t *testing.T) {
+	server := newTestServer(testData())
+	defer server.Close()
+	downloader := newTestDownloader(t, server)
+	checksum := fmt.Sprintf("%x", sha1.Sum(server.data))
+	if err := downloader.Download(context.Background(), checksum); err != ErrDuplicatedDownload {
+		t.Fatalf("Download error = %v; want ErrDuplicatedDownload", err)
+	}
+	if got := server.requests[0].Header.Get("If-None-Match"); got != server.etag {
+		t.Errorf("If-None-Match = %q; want %q", got, server.etag)
+	}
+}
+
+func TestDownloadChecksumMismatch(t *testing.T) {
+	server := newTestServer(testData())
+	defer server.Close()
+	server.etag = `"sha1-0000000000000000000000000000000000000000"`
+	downloader := newTestDownloader(t, server)
+	if err := ioutil.WriteFile(downloader.path, []byte("old"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := downloader.Download(context.Background(), ""); err == nil {
+		t.Fatal("Download succeeded with mismatched checksum")
+	}
+	if data, _ := ioutil.ReadFile(downloader.path); string(data) != "old" {
+		t.Errorf("data file replaced by unverified download")
+	}
+}
+
+func TestDownloadResume(t *testing.T) {
+	server := newTestServer(testData())
+	defer server.Close()
+	downloader := newTestDownloader(t, server)
+	half := len(server.data) / 2
+	if err := ioutil.WriteFile(downloader.path+".tmp", server.data[:half], 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(downloader.path+".tmp.etag", []byte(server.etag), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := downloader.Download(context.Background(), ""); err != nil {
+		t.Fatalf("Download error = %v", err)
+	}
+	if got, want := server.requests[0].Header.Get("Range"), fmt.Sprintf("bytes=%d-", half); got != want {
+		t.Errorf("Range = %q; want %q", got, want)
+	}
+	if data, err := ioutil.ReadFile(downloader.path); err != nil || !bytes.Equal(data, server.data) {
+		t.Errorf("resumed data mismatch: error=%v", err)
+	}
+}
+
+func TestDownloadRestartOnChangedETag(t *testing.T) {
+	server := newTestServer(testData())
+	defer server.Close()
+	downloader := newTestDownloader(t, server)
+	if err := ioutil.WriteFile(downloader.path+".tmp", []byte("stale partial content"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(downloader.path+".tmp.etag", []byte(`"sha1-stale"`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := downloader.Download(context.Background(), ""); err != nil {
+		t.Fatalf("Download error = %v", err)
+	}
+	if data, err := ioutil.ReadFile(downloader.path); err != nil || !bytes.Equal(data, server.data) {
+		t.Errorf("downloaded data mismatch: error=%v", err)
+	}
+}
+
+func TestDownloadCanceled(t *testing.T) {
+	server := newTestServer(testData())
+	defer server.Close()
+	downloader := newTestDownloader(t, server)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := downloader.Download(ctx, ""); err == nil {
+		t.Fatal("Download succeeded with canceled context")
+	}
+}
+
+func TestDownloadProxy(t *testing.T) {
+	proxy := newTestServer(testData())
+	defer proxy.Close()
+	downloader, err := NewDownloader("http://ipipnet.invalid/ipipnet.dat",
+		filepath.Join(t.TempDir(), "ipipnet.dat"), time.Second, proxy.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := downloader.Download(context.Background(), ""); err != nil {
+		t.Fatalf("Download error = %v", err)
+	}
+	if len(proxy.requests) != 1 || proxy.requests[0].Host != "ipipnet.invalid" {
+		t.Errorf("request not sent through proxy: %v", proxy.requests)
+	}
+}
+
+func TestReloadIfModifiedSince(t *testing.T) {
+	server := newTestServer([]byte(testNewText))
+	defer server.Close()
+	locator, err := newTestLocator(t, []byte(testOldText), "ipipnet.txt", func(config *Config) {
+		config.DataURL = server.URL
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := locator.reload(); err != nil {
+		t.Fatalf("reload error = %v", err)
+	}
+	// 替换数据文件后暂存文件已不存在，If-Modified-Since取自当前数据文件
+	if err := locator.reload(); err != ErrDuplicatedDownload {
+		t.Fatalf("second reload error = %v; want ErrDuplicatedDownload", err)
+	}
+	info, err := os.Stat(locator.config.DataPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := info.ModTime().UTC().Format(http.TimeFormat)
+	if got := server.requests[1].Header.Get("If-Modified-Since"); got != want {
+		t.Errorf("If-Modified-Since = %q; want %q", got, want)
+	}
+}
@@ -0,0 +1,378 @@
+package ipipnet
+
+import (
+	"encoding/binary"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var exportCSVHeader = []string{"start", "end", "cidr", "country_id", "country", "province_id", "province",
+	"city_id", "city", "isp_ids", "isps"}
+
+// walkCIDRs 按地址顺序把每个区段拆分为CIDR，没有Location的区段被跳过
+func (index *ipIndex) walkCIDRs(fn func(cidr *net.IPNet, location *Location) error) error {
+	var cidrs []*net.IPNet
+	for _, section := range index.sections {
+		if section.Location == nil {
+			continue
+		}
+		cidrs = appendCIDRs(cidrs[:0], uint128{lo: uint64(section.lower)}, uint128{lo: uint64(section.upper)}, 32)
+		for _, cidr := range cidrs {
+			if err := fn(cidr, section.Location); err != nil {
+				return err
+			}
+		}
+	}
+	for _, section := range index.sections6 {
+		if section.Location == nil {
+			continue
+		}
+		cidrs = appendCIDRs(cidrs[:0], section.lower, section.upper, 128)
+		for _, cidr := range cidrs {
+			if err := fn(cidr, section.Location); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// lastIP 返回CIDR的最后一个地址
+func lastIP(cidr *net.IPNet) net.IP {
+	ip := make(net.IP, len(cidr.IP))
+	for i := range ip {
+		ip[i] = cidr.IP[i] | ^cidr.Mask[i]
+	}
+	return ip
+}
+
+// ExportCSV 按地址顺序把当前数据写成CSV，每个CIDR一行，多个ISP的ID和名称以"/"分隔。
+// 没有地点和ISP的区段以及覆盖文件中的条目不会导出
+func (locator *Locator) ExportCSV(w io.Writer) error {
+	writer := csv.NewWriter(w)
+	if err := writer.Write(exportCSVHeader); err != nil {
+		return err
+	}
+	index := locator.data.Load().index
+	err := index.walkCIDRs(func(cidr *net.IPNet, location *Location) error {
+		if location.Country == nil && len(location.ISPs) == 0 {
+			return nil
+		}
+		ispIDs := make([]string, len(location.ISPs))
+		ispNames := make([]string, len(location.ISPs))
+		for i, isp := range location.ISPs {
+			ispIDs[i] = strconv.FormatUint(isp.ID, 10)
+			ispNames[i] = isp.Name
+		}
+		return writer.Write([]string{
+			cidr.IP.String(), lastIP(cidr).String(), cidr.String(),
+			exportID(location.GetCountryID()), location.GetCountryName(),
+			exportID(location.GetProvinceID()), location.GetProvinceName(),
+			exportID(location.GetCityID()), location.GetCityName(),
+			strings.Join(ispIDs, "/"), strings.Join(ispNames, "/"),
+		})
+	})
+	if err != nil {
+		return err
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+func exportID(id uint64) string {
+	if id == 0 {
+		return ""
+	}
+	return strconv.FormatUint(id, 10)
+}
+
+// ExportMMDB 把当前数据写成MaxMind DB格式，记录采用GeoIP2的结构，名称写在language下，
+// 为空时使用zh-CN。地点和ISP的ID写在ipipnet_id和isp_ids中。
+// IPv4地址位于::/96之下，覆盖文件中的条目不会导出
+func (locator *Locator) ExportMMDB(w io.Writer, language string) error {
+	if language == "" || language == defaultLanguage {
+		language = defaultMMDBLanguage
+	}
+	index := locator.data.Load().index
+	writer := &mmdbWriter{
+		nodes:     [][2]int{{}},
+		offsets:   make(map[*Location]int),
+		encodings: make(map[string]int),
+	}
+	hasIPv4 := len(index.sections) > 0
+	err := index.walkCIDRs(func(cidr *net.IPNet, location *Location) error {
+		ip := cidr.IP.To16()
+		ones, _ := cidr.Mask.Size()
+		if len(cidr.IP) == net.IPv4len {
+			ip = make(net.IP, net.IPv6len)
+			copy(ip[12:], cidr.IP)
+			ones += 96
+		} else if hasIPv4 && ones >= 96 && binary.BigEndian.Uint64(ip) == 0 && binary.BigEndian.Uint32(ip[8:]) == 0 {
+			// 来自MMDB的数据在::/96下重复了IPv4区段
+			return nil
+		}
+		offset, found := writer.record(location, language)
+		if !found {
+			return nil
+		}
+		return writer.insert(ip, ones, offset)
+	})
+	if err != nil {
+		return err
+	}
+	return writer.write(w, index, language)
+}
+
+// mmdbWriter 在内存中构造搜索树和数据区
+type mmdbWriter struct {
+	// 子节点大于0为节点序号，0为空，小于0为数据偏移加1后取负
+	nodes     [][2]int
+	data      []byte
+	offsets   map[*Location]int
+	encodings map[string]int
+}
+
+// record 返回Location在数据区中的偏移，内容相同的记录只写一次，没有任何信息时返回false
+func (writer *mmdbWriter) record(location *Location, language string) (int, bool) {
+	if offset, found := writer.offsets[location]; found {
+		return offset, offset >= 0
+	}
+	value := mmdbRecord(location, language)
+	offset := -1
+	if len(value) > 0 {
+		encoding := string(appendMMDBValue(nil, value))
+		var found bool
+		if offset, found = writer.encodings[encoding]; !found {
+			offset = len(writer.data)
+			writer.data = append(writer.data, encoding...)
+			writer.encodings[encoding] = offset
+		}
+	}
+	writer.offsets[location] = offset
+	return offset, offset >= 0
+}
+
+func (writer *mmdbWriter) insert(ip net.IP, ones int, offset int) error {
+	if ones == 0 {
+		// 根节点不能是叶子，拆分为两半
+		upper := make(net.IP, net.IPv6len)
+		upper[0] = 0x80
+		if err := writer.insert(ip, 1, offset); err != nil {
+			return err
+		}
+		return writer.insert(upper, 1, offset)
+	}
+	node := 0
+	for i := 0; i < ones; i++ {
+		bit := int(ip[i/8]>>(7-uint(i%8))) & 1
+		child := writer.nodes[node][bit]
+		if i == ones-1 {
+			if child != 0 {
+				return fmt.Errorf("导出MMDB出错: 区段重叠: cidr=%s/%d", ip, ones)
+			}
+			writer.nodes[node][bit] = -(offset + 1)
+			return nil
+		}
+		if child < 0 {
+			return fmt.Errorf("导出MMDB出错: 区段重叠: cidr=%s/%d", ip, ones)
+		} else if child == 0 {
+			writer.nodes = append(writer.nodes, [2]int{})
+			child = len(writer.nodes) - 1
+			writer.nodes[node][bit] = child
+		}
+		node = child
+	}
+	return nil
+}
+
+func (writer *mmdbWriter) write(w io.Writer, index *ipIndex, language string) error {
+	nodeCount := len(writer.nodes)
+	maxValue := uint64(nodeCount) + 16 + uint64(len(writer.data))
+	var recordSize int
+	switch {
+	case maxValue < 1<<24:
+		recordSize = 24
+	case maxValue < 1<<28:
+		recordSize = 28
+	case maxValue < 1<<32:
+		recordSize = 32
+	default:
+		return fmt.Errorf("导出MMDB出错: 数据过大: nodes=%d, data=%d", nodeCount, len(writer.data))
+	}
+	tree := make([]byte, 0, nodeCount*recordSize/4+16)
+	for _, node := range writer.nodes {
+		var records [2]uint32
+		for bit, child := range node {
+			switch {
+			case child > 0:
+				records[bit] = uint32(child)
+			case child == 0:
+				records[bit] = uint32(nodeCount)
+			default:
+				records[bit] = uint32(nodeCount + 16 - child - 1)
+			}
+		}
+		left, right := records[0], records[1]
+		switch recordSize {
+		case 24:
+			tree = append(tree, byte(left>>16), byte(left>>8), byte(left), byte(right>>16), byte(right>>8), byte(right))
+		case 28:
+			tree = append(tree, byte(left>>16), byte(left>>8), byte(left), byte(left>>24<<4|right>>24&0x0F),
+				byte(right>>16), byte(right>>8), byte(right))
+		default:
+			tree = binary.BigEndian.AppendUint32(tree, left)
+			tree = binary.BigEndian.AppendUint32(tree, right)
+		}
+	}
+	tree = append(tree, make([]byte, 16)...)
+	build := index.build
+	if build.IsZero() {
+		build = time.Now()
+	}
+	meta := append([]byte(nil), mmdbMetadataMarker...)
+	meta = appendMMDBValue(meta, map[string]interface{}{
+		"binary_format_major_version": uint64(2),
+		"binary_format_minor_version": uint64(0),
+		"build_epoch":                 uint64(build.Unix()),
+		"database_type":               "IPIP.net",
+		"description": map[string]interface{}{
+			"en": fmt.Sprintf("converted from ipipnet %s data, checksum %s", index.format, index.checksum),
+		},
+		"ip_version":  uint64(6),
+		"languages":   []interface{}{language},
+		"node_count":  uint64(nodeCount),
+		"record_size": uint64(recordSize),
+	})
+	for _, part := range [][]byte{tree, writer.data, meta} {
+		if _, err := w.Write(part); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// mmdbRecord 按GeoIP2的结构生成记录，与mmdbLocation读取的字段对应
+func mmdbRecord(location *Location, language string) map[string]interface{} {
+	record := make(map[string]interface{})
+	place := func(place *Place) map[string]interface{} {
+		value := map[string]interface{}{
+			"names": map[string]interface{}{language: place.Name},
+		}
+		if place.ID != 0 {
+			value["ipipnet_id"] = place.ID
+		}
+		return value
+	}
+	country := make(map[string]interface{})
+	if location.Country != nil {
+		country = place(location.Country)
+	}
+	if location.CountryCode != "" {
+		country["iso_code"] = location.CountryCode
+	}
+	if len(country) > 0 {
+		record["country"] = country
+	}
+	if location.Province != nil {
+		record["subdivisions"] = []interface{}{place(location.Province)}
+	}
+	if location.City != nil {
+		record["city"] = place(location.City)
+	}
+	if len(location.ISPs) > 0 {
+		names := make([]string, len(location.ISPs))
+		ids := make([]interface{}, len(location.ISPs))
+		for i, isp := range location.ISPs {
+			names[i] = isp.Name
+			ids[i] = isp.ID
+		}
+		record["isp"] = strings.Join(names, "/")
+		record["isp_ids"] = ids
+	}
+	if location.ContinentCode != "" {
+		record["continent"] = map[string]interface{}{"code": location.ContinentCode}
+	}
+	position := make(map[string]interface{})
+	if location.Latitude != 0 || location.Longitude != 0 {
+		position["latitude"] = location.Latitude
+		position["longitude"] = location.Longitude
+	}
+	if location.Timezone != "" {
+		position["time_zone"] = location.Timezone
+	}
+	if len(position) > 0 {
+		record["location"] = position
+	}
+	return record
+}
+
+// appendMMDBControl 追加类型和长度，扩展类型使用第二个字节
+func appendMMDBControl(buf []byte, typ, size int) []byte {
+	var extra []byte
+	switch {
+	case size < 29:
+	case size < 285:
+		extra = []byte{byte(size - 29)}
+		size = 29
+	case size < 65821:
+		extra = []byte{byte((size - 285) >> 8), byte(size - 285)}
+		size = 30
+	default:
+		extra = []byte{byte((size - 65821) >> 16), byte((size - 65821) >> 8), byte(size - 65821)}
+		size = 31
+	}
+	if typ <= 7 {
+		buf = append(buf, byte(typ<<5|size))
+	} else {
+		buf = append(buf, byte(size), byte(typ-7))
+	}
+	return append(buf, extra...)
+}
+
+// appendMMDBValue 编码string、uint64、float64、[]interface{}和map[string]interface{}，map的键按字典序排列
+func appendMMDBValue(buf []byte, value interface{}) []byte {
+	switch v := value.(type) {
+	case string:
+		return append(appendMMDBControl(buf, 2, len(v)), v...)
+	case uint64:
+		var b [8]byte
+		binary.BigEndian.PutUint64(b[:], v)
+		n := 0
+		for n < 8 && b[n] == 0 {
+			n++
+		}
+		typ := 6 // uint32
+		if v > math.MaxUint32 {
+			typ = 9 // uint64
+		}
+		return append(appendMMDBControl(buf, typ, 8-n), b[n:]...)
+	case float64:
+		return binary.BigEndian.AppendUint64(appendMMDBControl(buf, 3, 8), math.Float64bits(v))
+	case []interface{}:
+		buf = appendMMDBControl(buf, 11, len(v))
+		for _, item := range v {
+			buf = appendMMDBValue(buf, item)
+		}
+		return buf
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+		for key := range v {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		buf = appendMMDBControl(buf, 7, len(v))
+		for _, key := range keys {
+			buf = appendMMDBValue(buf, key)
+			buf = appendMMDBValue(buf, v[key])
+		}
+		return buf
+	}
+	panic(fmt.Sprintf("不支持的MMDB数据类型: %T", value))
+}
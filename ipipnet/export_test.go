@@ -0,0 +1,128 @@
+package ipipnet
+
+import (
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"net"
+	"net/netip"
+	"strings"
+	"testing"
+)
+
+// exportKey 返回导出后应保持不变的字段，没有任何信息的Location与nil等同
+func exportKey(location *Location) string {
+	if location == nil {
+		location = new(Location)
+	}
+	return fmt.Sprintf("%s|%s|%s|%s|%v|%v", location.String(), location.CountryCode, location.ContinentCode,
+		location.Timezone, location.Latitude, location.Longitude)
+}
+
+// checkSameLookups 比较两个Locator在全部区段边界和随机地址上的查询结果
+func checkSameLookups(t *testing.T, expected, actual *Locator, ipv6 bool) {
+	var addrs []netip.Addr
+	expected.Walk(func(start, end net.IP, location *Location) bool {
+		for _, ip := range []net.IP{start, end} {
+			addr, _ := netip.AddrFromSlice(ip)
+			addrs = append(addrs, addr.Unmap())
+		}
+		return true
+	})
+	addrs = append(addrs, randomAddrs(10000)...)
+	if ipv6 {
+		addrs = append(addrs, netip.MustParseAddr("2001:db8::1"), netip.MustParseAddr("2001:db9::"))
+	}
+	for _, addr := range addrs {
+		want, err := expected.LocateAddr(addr)
+		if err != nil {
+			t.Fatal(addr, err)
+		}
+		got, err := actual.LocateAddr(addr)
+		if err != nil {
+			t.Fatal(addr, err)
+		}
+		if exportKey(got) != exportKey(want) {
+			t.Fatalf("%s: expected %q, got %q", addr, exportKey(want), exportKey(got))
+		}
+	}
+}
+
+func exportMMDB(t *testing.T, locator *Locator) *Locator {
+	var buffer bytes.Buffer
+	if err := locator.ExportMMDB(&buffer, ""); err != nil {
+		t.Fatal(err)
+	}
+	exported, err := newTestLocator(t, buffer.Bytes(), "ipipnet.mmdb", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if format := exported.Metadata().Format; format != "mmdb" {
+		t.Fatalf("unexpected format: %s", format)
+	}
+	return exported
+}
+
+func TestExportMMDB(t *testing.T) {
+	locator, err := newTestLocator(t, syntheticData(t, 2000), "ipipnet.dat", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkSameLookups(t, locator, exportMMDB(t, locator), false)
+}
+
+func TestExportMMDBText(t *testing.T) {
+	text := "0.0.0.0\t0.255.255.255\t保留地址\n" +
+		"1.0.0.0\t1.0.0.255\t中国\t广东\t广州\t电信\tCN\tAP\tAsia/Shanghai\t23.129163\t113.264435\n" +
+		"1.0.1.0\t1.0.1.10\t中国\t广东\t\t电信/联通\n" +
+		"1.0.1.11\t1.0.1.11\t中国\t\t\t\tCN\n" +
+		"2001:db8::\t2001:db8::ffff\t中国\t广东\t\t电信\n" +
+		"2001:db8:1::\t2001:db8:1::10\t\t\t\t\t\tAP\n"
+	locator, err := newTestLocator(t, []byte(text), "ipipnet.txt", func(config *Config) {
+		config.Columns = []string{"country", "province", "city", "isp", "country_code", "continent_code", "timezone", "latitude", "longitude"}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkSameLookups(t, locator, exportMMDB(t, locator), true)
+}
+
+func TestExportCSV(t *testing.T) {
+	locator, err := newTestLocator(t, syntheticData(t, 2000), "ipipnet.dat", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buffer bytes.Buffer
+	if err := locator.ExportCSV(&buffer); err != nil {
+		t.Fatal(err)
+	}
+	rows, err := csv.NewReader(&buffer).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(rows[0], ",") != strings.Join(exportCSVHeader, ",") {
+		t.Fatalf("unexpected header: %v", rows[0])
+	}
+	next, count := netip.MustParseAddr("0.0.0.0"), 0
+	for _, row := range rows[1:] {
+		start, end, prefix := netip.MustParseAddr(row[0]), netip.MustParseAddr(row[1]), netip.MustParsePrefix(row[2])
+		if start.Less(next) || prefix.Addr() != start || !prefix.Contains(end) {
+			t.Fatalf("unexpected row: %v, next=%s", row, next)
+		}
+		next, count = end.Next(), count+1
+		for _, addr := range []netip.Addr{start, end} {
+			location, err := locator.LocateAddr(addr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			isps := strings.Join(location.GetISPNames(), "/")
+			if row[3] != exportID(location.GetCountryID()) || row[4] != location.GetCountryName() ||
+				row[5] != exportID(location.GetProvinceID()) || row[8] != location.GetCityName() || row[10] != isps {
+				t.Fatalf("%s: row %v does not match %s", addr, row, location)
+			}
+		}
+	}
+	if count < 2000 {
+		t.Fatalf("too few rows: %d", count)
+	}
+}
@@ -0,0 +1,176 @@
+// Package httpapi 以HTTP接口提供IP定位服务
+package httpapi
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/yangchenxing/foochow/ipipnet"
+	"github.com/yangchenxing/foochow/stats"
+)
+
+const (
+	defaultMaxBatch     = 1000
+	defaultMaxBodyBytes = 1 << 20
+)
+
+// Result 是单个IP的定位结果
+type Result struct {
+	IP       string            `json:"ip"`
+	Location *ipipnet.Location `json:"location"`
+	Error    string            `json:"error,omitempty"`
+}
+
+// Handler 提供IP定位接口，可挂载到任意路径:
+//
+//	GET  ?ip=1.2.3.4  查询单个IP，未指定ip时查询客户端地址
+//	POST              请求体为IP字符串的JSON数组，返回结果数组
+//
+// 应答头带数据版本信息和本次请求的查询数、错误数及耗时，
+// 请求次数、查询数、错误数和耗时同时累计到stats
+type Handler struct {
+	database ipipnet.Database
+	// TrustForwardedFor 为true时客户端地址取X-Forwarded-For的第一个地址，只应在可信代理之后开启
+	TrustForwardedFor bool
+	MaxBatch          int
+	requests          *stats.Counter
+	lookups           *stats.Counter
+	errors            *stats.Counter
+	latency           *stats.Averager
+}
+
+// NewHandler 创建HTTP接口，统计项以prefix为前缀注册，相同prefix的Handler共用统计项
+func NewHandler(database ipipnet.Database, prefix string) *Handler {
+	return &Handler{
+		database: database,
+		MaxBatch: defaultMaxBatch,
+		requests: stats.GetOrNewCounter(prefix + ".requests"),
+		lookups:  stats.GetOrNewCounter(prefix + ".lookups"),
+		errors:   stats.GetOrNewCounter(prefix + ".errors"),
+		latency:  stats.GetOrNewAverager(prefix+".latency", prefix+".latency.sum", prefix+".latency.count"),
+	}
+}
+
+// requestStats 是单个请求的统计，写在应答头中
+type requestStats struct {
+	start   time.Time
+	lookups int
+	errors  int
+}
+
+func (handler *Handler) ServeHTTP(response http.ResponseWriter, request *http.Request) {
+	current := &requestStats{start: time.Now()}
+	handler.requests.Add(1)
+	defer func() {
+		handler.latency.Add(float64(time.Since(current.start)) / float64(time.Millisecond))
+	}()
+	handler.setVersionHeaders(response.Header())
+	switch request.Method {
+	case http.MethodGet, http.MethodHead:
+		text := request.URL.Query().Get("ip")
+		if text == "" {
+			text = handler.clientIP(request)
+		}
+		result := handler.locate(text, current)
+		if result.Error != "" {
+			handler.writeJSON(response, http.StatusBadRequest, result, current)
+			return
+		}
+		handler.writeJSON(response, http.StatusOK, result, current)
+	case http.MethodPost:
+		var ips []string
+		if err := json.NewDecoder(http.MaxBytesReader(response, request.Body, defaultMaxBodyBytes)).Decode(&ips); err != nil {
+			handler.writeError(response, http.StatusBadRequest, fmt.Sprintf("解析请求出错: %s", err.Error()), current)
+			return
+		}
+		if len(ips) > handler.MaxBatch {
+			handler.writeError(response, http.StatusRequestEntityTooLarge,
+				fmt.Sprintf("批量查询数量超过上限: count=%d, max=%d", len(ips), handler.MaxBatch), current)
+			return
+		}
+		results := make([]Result, len(ips))
+		for i, text := range ips {
+			results[i] = handler.locate(text, current)
+		}
+		handler.writeJSON(response, http.StatusOK, results, current)
+	default:
+		response.Header().Set("Allow", "GET, HEAD, POST")
+		handler.writeError(response, http.StatusMethodNotAllowed, "不支持的请求方法", current)
+	}
+}
+
+func (handler *Handler) locate(text string, current *requestStats) Result {
+	handler.lookups.Add(1)
+	current.lookups++
+	result := Result{IP: text}
+	ip := net.ParseIP(strings.TrimSpace(text))
+	var err error
+	if ip == nil {
+		err = ipipnet.ErrInvalidIP
+	} else {
+		result.Location, err = handler.database.Locate(ip)
+	}
+	if err != nil {
+		handler.errors.Add(1)
+		current.errors++
+		result.Error = err.Error()
+	}
+	return result
+}
+
+// clientIP 返回客户端地址
+func (handler *Handler) clientIP(request *http.Request) string {
+	if handler.TrustForwardedFor {
+		if forwarded := request.Header.Get("X-Forwarded-For"); forwarded != "" {
+			if i := strings.IndexByte(forwarded, ','); i >= 0 {
+				forwarded = forwarded[:i]
+			}
+			return strings.TrimSpace(forwarded)
+		}
+	}
+	host, _, err := net.SplitHostPort(request.RemoteAddr)
+	if err != nil {
+		return request.RemoteAddr
+	}
+	return host
+}
+
+func (handler *Handler) setVersionHeaders(header http.Header) {
+	source, ok := handler.database.(interface {
+		Metadata() *ipipnet.Metadata
+	})
+	if !ok {
+		return
+	}
+	meta := source.Metadata()
+	if meta == nil {
+		return
+	}
+	header.Set("X-Dataset-Checksum", meta.Checksum)
+	header.Set("X-Dataset-Format", meta.Format)
+	header.Set("X-Dataset-Load-Time", meta.LoadTime.UTC().Format(time.RFC3339))
+	if !meta.Build.IsZero() {
+		header.Set("X-Dataset-Build", meta.Build.UTC().Format(time.RFC3339))
+	}
+}
+
+func (handler *Handler) writeError(response http.ResponseWriter, status int, message string, current *requestStats) {
+	handler.errors.Add(1)
+	current.errors++
+	handler.writeJSON(response, status, map[string]string{"error": message}, current)
+}
+
+func (handler *Handler) writeJSON(response http.ResponseWriter, status int, value interface{}, current *requestStats) {
+	header := response.Header()
+	header.Set("Content-Type", "application/json; charset=utf-8")
+	header.Set("X-Lookup-Count", strconv.Itoa(current.lookups))
+	header.Set("X-Lookup-Errors", strconv.Itoa(current.errors))
+	header.Set("Server-Timing", fmt.Sprintf("locate;dur=%.3f", float64(time.Since(current.start))/float64(time.Millisecond)))
+	response.WriteHeader(status)
+	json.NewEncoder(response).Encode(value)
+}
@@ -0,0 +1,145 @@
+package httpapi
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/yangchenxing/foochow/ipipnet"
+	"github.com/yangchenxing/foochow/stats"
+)
+
+const (
+	testIDs  = "region,中国,1\nregion,中国,广东,2\nregion,中国,广东,广州,3\nisp,电信,10\n"
+	testData = "1.0.0.0\t1.0.0.255\t中国\t广东\t广州\t\t电信\n" +
+		"2.0.0.0\t2.0.0.255\t中国\t\t\t\t\n"
+)
+
+func newTestHandler(t *testing.T) *Handler {
+	dir := t.TempDir()
+	config := ipipnet.Config{
+		IDsPath:  filepath.Join(dir, "ipipnet.ids"),
+		DataPath: filepath.Join(dir, "ipipnet.txt"),
+	}
+	if err := ioutil.WriteFile(config.IDsPath, []byte(testIDs), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(config.DataPath, []byte(testData), 0644); err != nil {
+		t.Fatal(err)
+	}
+	locator, err := ipipnet.New(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(locator.Close)
+	return NewHandler(locator, "test.ipipnet")
+}
+
+func serve(handler http.Handler, request *http.Request) (*httptest.ResponseRecorder, []byte) {
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, request)
+	body, _ := ioutil.ReadAll(recorder.Body)
+	return recorder, body
+}
+
+func TestLocate(t *testing.T) {
+	handler := newTestHandler(t)
+	recorder, body := serve(handler, httptest.NewRequest("GET", "/locate?ip=1.0.0.1", nil))
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("status = %d; body = %s", recorder.Code, body)
+	}
+	var result Result
+	if err := json.Unmarshal(body, &result); err != nil {
+		t.Fatal(err)
+	}
+	if result.IP != "1.0.0.1" || result.Location.GetCityID() != 3 || result.Location.GetISPIDs()[0] != 10 {
+		t.Errorf("result = %s", body)
+	}
+	if recorder.Header().Get("X-Dataset-Checksum") == "" || recorder.Header().Get("X-Dataset-Format") != "text" {
+		t.Errorf("missing dataset headers: %v", recorder.Header())
+	}
+	if recorder, body := serve(handler, httptest.NewRequest("GET", "/locate?ip=bad", nil)); recorder.Code != http.StatusBadRequest {
+		t.Errorf("invalid ip status = %d; body = %s", recorder.Code, body)
+	}
+}
+
+func TestLocateClientIP(t *testing.T) {
+	handler := newTestHandler(t)
+	request := httptest.NewRequest("GET", "/locate", nil)
+	request.RemoteAddr = "2.0.0.1:1234"
+	request.Header.Set("X-Forwarded-For", "1.0.0.1, 10.0.0.1")
+	for _, test := range []struct {
+		trust bool
+		want  string
+	}{
+		{false, "2.0.0.1"},
+		{true, "1.0.0.1"},
+	} {
+		handler.TrustForwardedFor = test.trust
+		_, body := serve(handler, request)
+		var result Result
+		if err := json.Unmarshal(body, &result); err != nil || result.IP != test.want {
+			t.Errorf("TrustForwardedFor=%v: result = %s; want ip %s", test.trust, body, test.want)
+		}
+	}
+}
+
+func TestLocateBatch(t *testing.T) {
+	handler := newTestHandler(t)
+	request := httptest.NewRequest("POST", "/locate", strings.NewReader(`["1.0.0.1", "2.0.0.1", "bad", "3.0.0.1"]`))
+	recorder, body := serve(handler, request)
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("status = %d; body = %s", recorder.Code, body)
+	}
+	var results []Result
+	if err := json.Unmarshal(body, &results); err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 4 || results[0].Location.GetCityID() != 3 || results[1].Location.GetCountryID() != 1 ||
+		results[2].Error == "" || results[3].Location != nil {
+		t.Errorf("results = %s", body)
+	}
+	if header := recorder.Header(); header.Get("X-Lookup-Count") != "4" || header.Get("X-Lookup-Errors") != "1" ||
+		!strings.HasPrefix(header.Get("Server-Timing"), "locate;dur=") {
+		t.Errorf("unexpected request stats headers: %v", header)
+	}
+	handler.MaxBatch = 1
+	request = httptest.NewRequest("POST", "/locate", strings.NewReader(`["1.0.0.1", "2.0.0.1"]`))
+	if recorder, _ := serve(handler, request); recorder.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("oversized batch status = %d", recorder.Code)
+	}
+}
+
+func TestSharedStats(t *testing.T) {
+	first, second := newTestHandler(t), newTestHandler(t)
+	if first.requests != second.requests || first.lookups != second.lookups || first.errors != second.errors ||
+		first.latency != second.latency {
+		t.Fatal("handlers with the same prefix do not share stats")
+	}
+	if stats.GetStatsItem("test.ipipnet.lookups") != stats.StatsItem(first.lookups) {
+		t.Fatal("registered counter was replaced")
+	}
+}
+
+func TestSharedStatsConcurrent(t *testing.T) {
+	handlers := make([]*Handler, 8)
+	var wg sync.WaitGroup
+	for i := range handlers {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			handlers[i] = NewHandler(nil, "test.concurrent")
+		}(i)
+	}
+	wg.Wait()
+	for _, handler := range handlers[1:] {
+		if handler.requests != handlers[0].requests || handler.latency != handlers[0].latency {
+			t.Fatal("concurrently created handlers do not share stats")
+		}
+	}
+}
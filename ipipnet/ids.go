@@ -8,30 +8,38 @@ import (
 	"strings"
 )
 
-var (
-	ids = struct {
-		places        map[string]placeTree
-		isps          map[string]*ISP
-		unknownPlaces map[string]bool
-		unknownISPs   map[string]bool
-	}{
-		places:        make(map[string]placeTree),
-		isps:          make(map[string]*ISP),
-		unknownPlaces: make(map[string]bool),
-		unknownISPs:   make(map[string]bool),
-	}
-)
+// idTable 加载完成后只读，可以在多个goroutine间共享
+type idTable struct {
+	places map[string]placeTree
+	isps   map[string]*ISP
+	// 按ID索引，由buildIDIndex生成
+	placeIDs   map[uint64]placeTree
+	placePaths map[uint64][]*Place
+	ispIDs     map[uint64]*ISP
+}
 
 type placeTree struct {
 	*Place
 	subplaces map[string]placeTree
 }
 
-func loadIDs() error {
-	file, err := os.Open(config.idsPath)
+func newIDTable() *idTable {
+	return &idTable{
+		places: make(map[string]placeTree),
+		isps:   make(map[string]*ISP),
+	}
+}
+
+func loadIDs(path string) (*idTable, error) {
+	file, err := os.Open(path)
 	if err != nil {
-		return err
+		return nil, err
 	}
+	defer file.Close()
+	ids := newIDTable()
+	// 已出现的ID及其名称，按ID索引时重复的ID会相互覆盖
+	placePaths := make(map[uint64]string)
+	ispNames := make(map[uint64]string)
 	scanner := bufio.NewScanner(file)
 	lineNum := 0
 	for scanner.Scan() {
@@ -42,44 +50,53 @@ func loadIDs() error {
 		case "region":
 			id, err := strconv.ParseUint(record[len(record)-1], 10, 64)
 			if err != nil {
-				return fmt.Errorf("错误的地域ID记录: line=%d, text=%q", lineNum, text)
+				return nil, fmt.Errorf("错误的地域ID记录: line=%d, text=%q", lineNum, text)
 			}
-			if len(record) >= 2 && len(record) <= 5 {
-				if err = setPlaceID(id, record[1:len(record)-1]); err != nil {
-					return fmt.Errorf("错误的地域ID记录: line=%d, text=%q, error=%q",
+			// 至少包含一级地名
+			if len(record) >= 3 && len(record) <= 5 && record[1] != "" {
+				path := strings.Join(record[1:len(record)-1], "/")
+				if previous, found := placePaths[id]; found {
+					return nil, fmt.Errorf("重复的地域ID: line=%d, path=%q, id=%d, previous=%q", lineNum, path, id, previous)
+				}
+				placePaths[id] = path
+				if err = ids.setPlaceID(id, record[1:len(record)-1]); err != nil {
+					return nil, fmt.Errorf("错误的地域ID记录: line=%d, text=%q, error=%q",
 						lineNum, text, err.Error())
 				}
 			} else {
-				return fmt.Errorf("错误的地域ID记录: line=%d, text=%q, error=%q",
-					lineNum, text, err.Error())
+				return nil, fmt.Errorf("错误的地域ID记录: line=%d, text=%q", lineNum, text)
 			}
 		case "isp":
 			id, err := strconv.ParseUint(record[len(record)-1], 10, 32)
 			if err != nil {
-				return fmt.Errorf("错误的ISP ID记录: line=%d, text=%q, error=%q",
+				return nil, fmt.Errorf("错误的ISP ID记录: line=%d, text=%q, error=%q",
 					lineNum, text, err.Error())
 			}
-			switch len(record) - 1 {
-			case 2:
+			switch {
+			case len(record) == 3 && record[1] != "":
 				if _, found := ids.isps[record[1]]; found {
-					return fmt.Errorf("重复的ISP ID: line=%d, text=%q", lineNum, text)
+					return nil, fmt.Errorf("重复的ISP ID: line=%d, text=%q", lineNum, text)
+				} else if previous, found := ispNames[id]; found {
+					return nil, fmt.Errorf("重复的ISP ID: line=%d, name=%q, id=%d, previous=%q", lineNum, record[1], id, previous)
 				} else {
+					ispNames[id] = record[1]
 					ids.isps[record[1]] = &ISP{
 						ID:   id,
 						Name: record[1],
 					}
 				}
 			default:
-				return fmt.Errorf("错误的ISP ID记录: line=%d, text=%q", lineNum, text)
+				return nil, fmt.Errorf("错误的ISP ID记录: line=%d, text=%q", lineNum, text)
 			}
 		default:
-			return fmt.Errorf("无效的ID记录: line=%d, text=%q", lineNum, text)
+			return nil, fmt.Errorf("无效的ID记录: line=%d, text=%q", lineNum, text)
 		}
 	}
-	return nil
+	ids.buildIDIndex()
+	return ids, nil
 }
 
-func setPlaceID(id uint64, names []string) error {
+func (ids *idTable) setPlaceID(id uint64, names []string) error {
 	if len(names) > 3 {
 		return fmt.Errorf("地域ID深度大于3")
 	}
@@ -107,3 +124,170 @@ func setPlaceID(id uint64, names []string) error {
 	places[names[depth]] = place
 	return nil
 }
+
+// locationBuilder 在一次数据加载中把文本转换为Location，并记录ID表中没有的名称
+type locationBuilder struct {
+	ids                  *idTable
+	unknownPlaces        map[string]bool
+	unknownISPs          map[string]bool
+	unknownLocations     map[*Location]*unknownNames
+	columns              []string
+	unknownPlaceCallback func(string)
+	unknownISPCallback   func(string)
+}
+
+// unknownNames 记录一个Location中ID文件未收录的名称
+type unknownNames struct {
+	place string
+	isps  []string
+}
+
+// legacyColumns 返回旧格式n列文本的列定义
+func legacyColumns(n int) []string {
+	columns := make([]string, n)
+	copy(columns, []string{"country", "province", "city"})
+	if n > 3 {
+		columns[n-1] = "isp"
+	}
+	return columns
+}
+
+func newLocationBuilder(ids *idTable, unknownPlaceCallback, unknownISPCallback func(string)) *locationBuilder {
+	return &locationBuilder{
+		ids:                  ids,
+		unknownPlaces:        make(map[string]bool),
+		unknownISPs:          make(map[string]bool),
+		unknownLocations:     make(map[*Location]*unknownNames),
+		unknownPlaceCallback: unknownPlaceCallback,
+		unknownISPCallback:   unknownISPCallback,
+	}
+}
+
+func (builder *locationBuilder) unknown(location *Location) *unknownNames {
+	names := builder.unknownLocations[location]
+	if names == nil {
+		names = new(unknownNames)
+		builder.unknownLocations[location] = names
+	}
+	return names
+}
+
+// getLocation 按配置的列定义解析Tab分隔的文本，未定义时前三列为地点，最后一列为ISP
+func (builder *locationBuilder) getLocation(data []byte) *Location {
+	values := strings.Split(string(data), "\t")
+	columns := builder.columns
+	if columns == nil {
+		columns = legacyColumns(len(values))
+	}
+	return builder.getLocationColumns(values, columns)
+}
+
+// getLocationColumns 把按columns命名的各列转换为Location
+func (builder *locationBuilder) getLocationColumns(values, columns []string) *Location {
+	placeNames := make([]string, 3)
+	var ispNames []string
+	location := new(Location)
+	for i, column := range columns {
+		if i >= len(values) {
+			break
+		}
+		value := values[i]
+		switch column {
+		case "country":
+			placeNames[0] = value
+		case "province":
+			placeNames[1] = value
+		case "city":
+			placeNames[2] = value
+		case "isp":
+			if value != "" {
+				ispNames = strings.Split(value, "/")
+			}
+		default:
+			location.setColumn(column, value)
+		}
+	}
+	// 获取地点
+	places := builder.ids.places
+	for i, name := range placeNames {
+		if i > 2 || name == "" || (i == 1 && name == placeNames[0]) {
+			break
+		}
+		if len(places) == 0 {
+			break
+		}
+		place, found := places[name]
+		if !found {
+			key := placePath(placeNames)
+			builder.unknown(location).place = key
+			if !builder.unknownPlaces[key] {
+				builder.unknownPlaces[key] = true
+				if builder.unknownPlaceCallback != nil {
+					builder.unknownPlaceCallback(key)
+				}
+			}
+			break
+		}
+		switch i {
+		case 0:
+			location.Country = place.Place
+		case 1:
+			location.Province = place.Place
+		case 2:
+			location.City = place.Place
+		}
+		places = place.subplaces
+	}
+	location.ISPs = make([]*ISP, 0, len(ispNames))
+	for _, name := range ispNames {
+		if name == "" {
+			continue
+		}
+		isp := builder.ids.isps[name]
+		if isp != nil {
+			location.ISPs = append(location.ISPs, isp)
+		} else {
+			unknown := builder.unknown(location)
+			unknown.isps = append(unknown.isps, name)
+			if !builder.unknownISPs[name] {
+				builder.unknownISPs[name] = true
+				if builder.unknownISPCallback != nil {
+					builder.unknownISPCallback(name)
+				}
+			}
+		}
+	}
+	return location
+}
+
+// placePath 返回数据中的完整地点路径，省份与国家同名时只取国家
+func placePath(names []string) string {
+	path := make([]string, 0, 3)
+	for i, name := range names {
+		if i > 2 || name == "" || (i == 1 && name == names[0]) {
+			break
+		}
+		path = append(path, name)
+	}
+	return strings.Join(path, "/")
+}
+
+// buildIDIndex 建立ID到地点、地点链和ISP的索引
+func (ids *idTable) buildIDIndex() {
+	ids.placeIDs = make(map[uint64]placeTree)
+	ids.placePaths = make(map[uint64][]*Place)
+	ids.ispIDs = make(map[uint64]*ISP, len(ids.isps))
+	var walk func(places map[string]placeTree, parents []*Place)
+	walk = func(places map[string]placeTree, parents []*Place) {
+		for _, place := range places {
+			path := append(parents[:len(parents):len(parents)], place.Place)
+			ids.placeIDs[place.ID] = place
+			ids.placePaths[place.ID] = path
+			walk(place.subplaces, path)
+		}
+	}
+	walk(ids.places, nil)
+	for _, isp := range ids.isps {
+		ids.ispIDs[isp.ID] = isp
+	}
+}
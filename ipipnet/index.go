@@ -0,0 +1,142 @@
+package ipipnet
+
+import (
+	"encoding/binary"
+	"net"
+	"sort"
+	"time"
+	"unsafe"
+)
+
+func ipToUint32(ip net.IP) uint32 {
+	return binary.BigEndian.Uint32(ip.To4())
+}
+
+type section struct {
+	*Location
+	lower uint32
+	upper uint32
+}
+
+// uint128 是IPv6地址的数值形式
+type uint128 struct {
+	hi uint64
+	lo uint64
+}
+
+func uint128FromIP(ip net.IP) uint128 {
+	ip = ip.To16()
+	return uint128{
+		hi: binary.BigEndian.Uint64(ip[:8]),
+		lo: binary.BigEndian.Uint64(ip[8:]),
+	}
+}
+
+func (v uint128) less(o uint128) bool {
+	return v.hi < o.hi || (v.hi == o.hi && v.lo < o.lo)
+}
+
+func (v uint128) toIP(size int) net.IP {
+	if size == 32 {
+		return net.IPv4(byte(v.lo>>24), byte(v.lo>>16), byte(v.lo>>8), byte(v.lo)).To4()
+	}
+	ip := make(net.IP, net.IPv6len)
+	for i := 0; i < 8; i++ {
+		ip[i] = byte(v.hi >> uint(56-8*i))
+		ip[8+i] = byte(v.lo >> uint(56-8*i))
+	}
+	return ip
+}
+
+type section6 struct {
+	*Location
+	lower uint128
+	upper uint128
+}
+
+type ipIndex struct {
+	sections []section
+	index    [256]struct {
+		lower int
+		upper int
+	}
+	sections6 []section6
+	// index16 是可选的按前16位划分的查找范围，存在时代替index
+	index16  []bucket16
+	checksum string
+	format   string
+	build    time.Time
+	ipdb     *IPDBMetadata
+}
+
+type bucket16 struct {
+	lower int32
+	upper int32
+}
+
+func (index *ipIndex) locate(v uint32) *Location {
+	var lower, upper int
+	if index.index16 != nil {
+		pos := index.index16[v>>16]
+		lower, upper = int(pos.lower), int(pos.upper)
+	} else {
+		pos := index.index[v>>24]
+		lower, upper = pos.lower, pos.upper
+	}
+	for lower <= upper {
+		mid := (lower + upper) / 2
+		section := index.sections[mid]
+		if v < section.lower {
+			upper = mid - 1
+		} else if v > section.upper {
+			lower = mid + 1
+		} else {
+			return section.Location
+		}
+	}
+	return nil
+}
+
+func (index *ipIndex) locate6(v uint128) *Location {
+	i := sort.Search(len(index.sections6), func(i int) bool {
+		return !index.sections6[i].upper.less(v)
+	})
+	if i < len(index.sections6) && !v.less(index.sections6[i].lower) {
+		return index.sections6[i].Location
+	}
+	return nil
+}
+
+// buildIndex 按首字节建立IPv4区段的查找范围，区段须已按地址排序且互不重叠。
+// 首字节为b的地址只可能落在上界首字节不小于b、且下界首字节不大于b的区段中
+func (index *ipIndex) buildIndex() {
+	sections := index.sections
+	for b := 0; b < 256; b++ {
+		index.index[b].lower = sort.Search(len(sections), func(i int) bool {
+			return int(sections[i].upper>>24) >= b
+		})
+		index.index[b].upper = sort.Search(len(sections), func(i int) bool {
+			return int(sections[i].lower>>24) > b
+		}) - 1
+	}
+}
+
+// buildIndex16 按前16位建立查找范围，占用512KB，每个范围内的区段数远少于按首字节划分
+func (index *ipIndex) buildIndex16() {
+	sections := index.sections
+	index.index16 = make([]bucket16, 1<<16)
+	for b := range index.index16 {
+		index.index16[b].lower = int32(sort.Search(len(sections), func(i int) bool {
+			return int(sections[i].upper>>16) >= b
+		}))
+		index.index16[b].upper = int32(sort.Search(len(sections), func(i int) bool {
+			return int(sections[i].lower>>16) > b
+		}) - 1)
+	}
+}
+
+// indexSize 返回IPv4区段和查找范围占用的字节数，不含Location
+func (index *ipIndex) indexSize() int {
+	return len(index.sections)*int(unsafe.Sizeof(section{})) +
+		int(unsafe.Sizeof(index.index)) + len(index.index16)*int(unsafe.Sizeof(bucket16{}))
+}
@@ -0,0 +1,196 @@
+package ipipnet
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	ipdbIPv4 = 0x01
+	ipdbIPv6 = 0x02
+
+	defaultLanguage = "CN"
+)
+
+// IPDBMetadata 是.ipdb文件头部的元数据
+type IPDBMetadata struct {
+	Build     time.Time
+	IPVersion uint16
+	Languages []string
+	Fields    []string
+	NodeCount int
+	TotalSize int
+}
+
+type ipdbHeader struct {
+	Build     int64          `json:"build"`
+	IPVersion uint16         `json:"ip_version"`
+	Languages map[string]int `json:"languages"`
+	NodeCount int            `json:"node_count"`
+	TotalSize int            `json:"total_size"`
+	Fields    []string       `json:"fields"`
+}
+
+// ipdbColumns 是IPDB字段到数据列的映射
+var ipdbColumns = map[string]string{
+	"country_name":     "country",
+	"region_name":      "province",
+	"city_name":        "city",
+	"district_name":    "district",
+	"isp_domain":       "isp",
+	"owner_domain":     "owner",
+	"country_code":     "country_code",
+	"continent_code":   "continent_code",
+	"latitude":         "latitude",
+	"longitude":        "longitude",
+	"timezone":         "timezone",
+	"utc_offset":       "utc_offset",
+	"china_admin_code": "admin_code",
+	"idd_code":         "idd_code",
+}
+
+type ipdbReader struct {
+	header   ipdbHeader
+	data     []byte
+	v4Offset int
+	language int
+	columns  []string
+	builder  *locationBuilder
+	// 相同记录共享同一个Location
+	locations map[int]*Location
+}
+
+func isIPDBData(data []byte) bool {
+	if len(data) < 6 {
+		return false
+	}
+	length := int(binary.BigEndian.Uint32(data[:4]))
+	return length > 1 && 4+length <= len(data) && data[4] == '{' && data[4+length-1] == '}'
+}
+
+func parseIPDB(data []byte, builder *locationBuilder, language string) (*ipIndex, error) {
+	reader, err := newIPDBReader(data, builder, language)
+	if err != nil {
+		return nil, err
+	}
+	newIndex := &ipIndex{
+		format: "ipdb",
+		build:  time.Unix(reader.header.Build, 0),
+		ipdb:   reader.metadata(),
+	}
+	v4Start := -1
+	if reader.header.IPVersion&ipdbIPv4 != 0 {
+		v4Start = reader.v4Offset
+	}
+	if err := reader.walker().walkIndex(newIndex, v4Start, reader.header.IPVersion&ipdbIPv6 != 0); err != nil {
+		return nil, err
+	}
+	newIndex.checksum = fmt.Sprintf("%x", sha1.Sum(data))
+	return newIndex, nil
+}
+
+func newIPDBReader(data []byte, builder *locationBuilder, language string) (*ipdbReader, error) {
+	if !isIPDBData(data) {
+		return nil, fmt.Errorf("不是IPDB格式")
+	}
+	length := int(binary.BigEndian.Uint32(data[:4]))
+	reader := &ipdbReader{
+		data:      data[4+length:],
+		builder:   builder,
+		locations: make(map[int]*Location),
+	}
+	decoder := json.NewDecoder(bytes.NewReader(data[4 : 4+length]))
+	if err := decoder.Decode(&reader.header); err != nil {
+		return nil, fmt.Errorf("解析IPDB元数据出错: %s", err.Error())
+	}
+	if len(reader.data) != reader.header.TotalSize {
+		return nil, fmt.Errorf("IPDB数据长度不符: expected=%d, actual=%d", reader.header.TotalSize, len(reader.data))
+	} else if reader.header.NodeCount <= 0 || reader.header.NodeCount > len(reader.data)/8 {
+		return nil, fmt.Errorf("IPDB节点数量无效: %d", reader.header.NodeCount)
+	} else if len(reader.header.Fields) == 0 {
+		return nil, fmt.Errorf("IPDB缺少字段定义")
+	}
+	if language == "" {
+		language = defaultLanguage
+	}
+	offset, found := reader.header.Languages[language]
+	if !found || offset < 0 {
+		return nil, fmt.Errorf("IPDB不支持语言%q: languages=%v", language, reader.metadata().Languages)
+	}
+	reader.language = offset
+	reader.columns = make([]string, len(reader.header.Fields))
+	for i, field := range reader.header.Fields {
+		reader.columns[i] = ipdbColumns[field]
+	}
+	// IPv4地址位于::ffff:0:0/96之下
+	node := 0
+	for i := 0; i < 96 && node < reader.header.NodeCount; i++ {
+		if i >= 80 {
+			node = reader.readNode(node, 1)
+		} else {
+			node = reader.readNode(node, 0)
+		}
+	}
+	reader.v4Offset = node
+	return reader, nil
+}
+
+func (reader *ipdbReader) metadata() *IPDBMetadata {
+	languages := make([]string, 0, len(reader.header.Languages))
+	for language := range reader.header.Languages {
+		languages = append(languages, language)
+	}
+	sort.Strings(languages)
+	return &IPDBMetadata{
+		Build:     time.Unix(reader.header.Build, 0),
+		IPVersion: reader.header.IPVersion,
+		Languages: languages,
+		Fields:    append([]string(nil), reader.header.Fields...),
+		NodeCount: reader.header.NodeCount,
+		TotalSize: reader.header.TotalSize,
+	}
+}
+
+func (reader *ipdbReader) readNode(node, bit int) int {
+	offset := node*8 + bit*4
+	return int(binary.BigEndian.Uint32(reader.data[offset : offset+4]))
+}
+
+func (reader *ipdbReader) walker() *trieWalker {
+	return &trieWalker{
+		nodeCount: reader.header.NodeCount,
+		readNode:  reader.readNode,
+		resolve:   reader.resolve,
+		v4Node:    reader.v4Offset,
+	}
+}
+
+func (reader *ipdbReader) resolve(node int) (*Location, error) {
+	if location, found := reader.locations[node]; found {
+		return location, nil
+	}
+	nodeCount := reader.header.NodeCount
+	offset := node - nodeCount + nodeCount*8
+	if offset+2 > len(reader.data) {
+		return nil, fmt.Errorf("IPDB记录偏移越界: node=%d", node)
+	}
+	size := int(binary.BigEndian.Uint16(reader.data[offset : offset+2]))
+	if offset+2+size > len(reader.data) {
+		return nil, fmt.Errorf("IPDB记录长度越界: node=%d, size=%d", node, size)
+	}
+	values := strings.Split(string(reader.data[offset+2:offset+2+size]), "\t")
+	fieldCount := len(reader.header.Fields)
+	if reader.language+fieldCount > len(values) {
+		return nil, fmt.Errorf("IPDB记录字段数不足: node=%d, fields=%d", node, len(values))
+	}
+	values = values[reader.language : reader.language+fieldCount]
+	location := reader.builder.getLocationColumns(values, reader.columns)
+	reader.locations[node] = location
+	return location, nil
+}
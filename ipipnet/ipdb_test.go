@@ -0,0 +1,177 @@
+package ipipnet
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const testIPDBIDs = testIDs + "region,China,101\nregion,China,Guangdong,102\nregion,China,Guangdong,Guangzhou,103\nisp,ChinaTelecom,110\n"
+
+type testIPDBRecord struct {
+	cidr string
+	// 依次为CN和EN两种语言的country_name、region_name、city_name和isp_domain
+	text string
+}
+
+// buildIPDB 构造.ipdb数据，IPv4网段放在::ffff:0:0/96之下
+func buildIPDB(t *testing.T, records []testIPDBRecord) []byte {
+	nodes := [][2]int{{-1, -1}}
+	// 子节点大于等于0为节点序号，-1为空，小于-1为记录序号加2后取负
+	for i, record := range records {
+		_, ipnet, err := net.ParseCIDR(record.cidr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ip := ipnet.IP.To16()
+		ones, _ := ipnet.Mask.Size()
+		if ip4 := ipnet.IP.To4(); ip4 != nil && ones <= 32 {
+			ip = net.IP(append([]byte{0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0xff, 0xff}, ip4...))
+			ones += 96
+		}
+		node := 0
+		for depth := 0; depth < ones; depth++ {
+			bit := int(ip[depth/8]>>uint(7-depth%8)) & 1
+			if depth == ones-1 {
+				nodes[node][bit] = -(i + 2)
+				break
+			}
+			if nodes[node][bit] < 0 {
+				nodes = append(nodes, [2]int{-1, -1})
+				nodes[node][bit] = len(nodes) - 1
+			}
+			node = nodes[node][bit]
+		}
+	}
+	nodeCount := len(nodes)
+	// 记录偏移为0时与无数据的节点号相同，因此从2开始
+	data := make([]byte, nodeCount*8+2)
+	offsets := make([]int, len(records))
+	for i, record := range records {
+		offsets[i] = len(data) - nodeCount*8
+		data = binary.BigEndian.AppendUint16(data, uint16(len(record.text)))
+		data = append(data, record.text...)
+	}
+	for i, node := range nodes {
+		for bit, child := range node {
+			value := child
+			if child == -1 {
+				value = nodeCount
+			} else if child < -1 {
+				value = nodeCount + offsets[-child-2]
+			}
+			binary.BigEndian.PutUint32(data[i*8+bit*4:], uint32(value))
+		}
+	}
+	header, err := json.Marshal(map[string]interface{}{
+		"build":      1500000000,
+		"ip_version": ipdbIPv4 | ipdbIPv6,
+		"languages":  map[string]int{"CN": 0, "EN": 4},
+		"node_count": nodeCount,
+		"total_size": len(data),
+		"fields":     []string{"country_name", "region_name", "city_name", "isp_domain"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	result := binary.BigEndian.AppendUint32(nil, uint32(len(header)))
+	result = append(result, header...)
+	return append(result, data...)
+}
+
+var testIPDBRecords = []testIPDBRecord{
+	{"1.0.0.0/8", "中国\t广东\t广州\t电信\tChina\tGuangdong\tGuangzhou\tChinaTelecom"},
+	{"2.0.0.0/16", "中国\t\t\t\tChina\t\t\t"},
+	{"2001:db8::/32", "中国\t广东\t\t电信\tChina\tGuangdong\t\tChinaTelecom"},
+}
+
+// withIPDB 返回改用IPDB测试ID文件和指定语言的配置修改，用于newTestLocator
+func withIPDB(t *testing.T, language string) func(*Config) {
+	return func(config *Config) {
+		config.Language = language
+		if err := ioutil.WriteFile(config.IDsPath, []byte(testIPDBIDs), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestIPDB(t *testing.T) {
+	data := buildIPDB(t, testIPDBRecords)
+	for _, test := range []struct {
+		language string
+		want     map[string]string
+	}{
+		{"", map[string]string{
+			"1.2.3.4":         "中国(1)/广东(2)/广州(3)/电信(10)",
+			"::ffff:1.2.3.4":  "中国(1)/广东(2)/广州(3)/电信(10)",
+			"2.0.255.255":     "中国(1)",
+			"2.1.0.0":         "<nil>",
+			"2001:db8:1::1":   "中国(1)/广东(2)/电信(10)",
+			"2001:db9::1":     "<nil>",
+			"0.255.255.255":   "<nil>",
+			"255.255.255.255": "<nil>",
+		}},
+		{"EN", map[string]string{
+			"1.2.3.4":       "China(101)/Guangdong(102)/Guangzhou(103)/ChinaTelecom(110)",
+			"2.0.0.1":       "China(101)",
+			"2001:db8:1::1": "China(101)/Guangdong(102)/ChinaTelecom(110)",
+		}},
+	} {
+		locator, err := newTestLocator(t, data, "ipipnet.ipdb", withIPDB(t, test.language))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for ip, want := range test.want {
+			location, err := locator.Locate(net.ParseIP(ip))
+			if err != nil {
+				t.Errorf("language %q: Locate(%s) error = %v", test.language, ip, err)
+			} else if got := locationString(location); got != want {
+				t.Errorf("language %q: Locate(%s) = %s; want %s", test.language, ip, got, want)
+			}
+		}
+		meta := locator.IPDBMetadata()
+		if meta == nil || !reflect.DeepEqual(meta.Languages, []string{"CN", "EN"}) || meta.Build.Unix() != 1500000000 ||
+			meta.IPVersion != ipdbIPv4|ipdbIPv6 || len(meta.Fields) != 4 {
+			t.Errorf("IPDBMetadata() = %+v", meta)
+		}
+		// IPv6遍历跳过IPv4子树，IPv4区段只出现一次
+		index := locator.data.Load().index
+		if len(index.sections) != 2 || len(index.sections6) != 1 {
+			t.Errorf("sections = %d, sections6 = %d", len(index.sections), len(index.sections6))
+		}
+	}
+}
+
+func TestIPDBInvalid(t *testing.T) {
+	data := buildIPDB(t, testIPDBRecords)
+	headerSize := 4 + int(binary.BigEndian.Uint32(data))
+	badRecord := append([]byte(nil), data...)
+	// 根节点的右子树指向数据区之外
+	binary.BigEndian.PutUint32(badRecord[headerSize+4:], 1<<20)
+	badHeader := append([]byte(nil), data...)
+	copy(badHeader[4:], `{"build":"`)
+	for _, test := range []struct {
+		name     string
+		data     []byte
+		language string
+		want     string
+	}{
+		{"unknown language", data, "JP", "IPDB不支持语言"},
+		{"truncated header", data[:headerSize-1], "", "不是IPDB格式"},
+		{"truncated data", data[:len(data)-1], "", "IPDB数据长度不符"},
+		{"bad header", badHeader, "", "解析IPDB元数据出错"},
+		{"bad record offset", badRecord, "", "IPDB记录偏移越界"},
+	} {
+		_, err := parseIPDB(test.data, newLocationBuilder(new(idTable), nil, nil), test.language)
+		if err == nil || !strings.Contains(err.Error(), test.want) {
+			t.Errorf("%s: error = %v; want %q", test.name, err, test.want)
+		}
+	}
+	if _, err := newTestLocator(t, data, "ipipnet.ipdb", withIPDB(t, "JP")); err == nil {
+		t.Error("New succeeded with unknown language")
+	}
+}
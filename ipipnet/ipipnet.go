@@ -0,0 +1,60 @@
+package ipipnet
+
+import (
+	"net"
+	"sync/atomic"
+)
+
+// Database 是各种IP数据源的统一查询接口，未找到时返回nil
+type Database interface {
+	Locate(ip net.IP) (*Location, error)
+}
+
+var (
+	_ Database = (*Locator)(nil)
+	_ Database = (*MMDBReader)(nil)
+
+	defaultLocator atomic.Pointer[Locator]
+
+	UnknownPlaceCallback func(string)
+	UnknownISPCallback   func(string)
+)
+
+// Initialize 创建默认Locator，供包级函数使用
+func Initialize(conf Config) error {
+	if conf.UnknownPlaceCallback == nil {
+		conf.UnknownPlaceCallback = func(name string) {
+			if UnknownPlaceCallback != nil {
+				UnknownPlaceCallback(name)
+			}
+		}
+	}
+	if conf.UnknownISPCallback == nil {
+		conf.UnknownISPCallback = func(name string) {
+			if UnknownISPCallback != nil {
+				UnknownISPCallback(name)
+			}
+		}
+	}
+	locator, err := New(conf)
+	if err != nil {
+		return err
+	}
+	if old := defaultLocator.Swap(locator); old != nil {
+		old.Close()
+	}
+	return nil
+}
+
+// Default 返回Initialize创建的Locator，未初始化时返回nil
+func Default() *Locator {
+	return defaultLocator.Load()
+}
+
+func Locate(ip net.IP) (*Location, error) {
+	locator := defaultLocator.Load()
+	if locator == nil {
+		return nil, ErrNotData
+	}
+	return locator.Locate(ip)
+}
@@ -2,6 +2,7 @@ package ipipnet
 
 import (
 	"fmt"
+	"strconv"
 	"strings"
 )
 
@@ -20,6 +21,66 @@ type Location struct {
 	Province *Place `json:"province"`
 	City     *Place `json:"city"`
 	ISPs     []*ISP `json:"isps"`
+	// 以下字段只在数据包含对应的列时有值
+	District      string  `json:"district,omitempty"`
+	Owner         string  `json:"owner,omitempty"`
+	CountryCode   string  `json:"country_code,omitempty"`
+	ContinentCode string  `json:"continent_code,omitempty"`
+	Latitude      float64 `json:"latitude,omitempty"`
+	Longitude     float64 `json:"longitude,omitempty"`
+	Timezone      string  `json:"timezone,omitempty"`
+	UTCOffset     string  `json:"utc_offset,omitempty"`
+	AdminCode     string  `json:"admin_code,omitempty"`
+	IDDCode       string  `json:"idd_code,omitempty"`
+	Override      bool    `json:"override,omitempty"` // 结果来自覆盖文件
+}
+
+// Columns 是数据文本中可以声明的列名，country、province、city和isp之外的列对应Location的扩展字段
+var Columns = []string{
+	"country", "province", "city", "isp", "district", "owner", "country_code", "continent_code",
+	"latitude", "longitude", "timezone", "utc_offset", "admin_code", "idd_code",
+}
+
+// checkColumns 检查列定义，空字符串或"-"表示忽略该列
+func checkColumns(columns []string) error {
+	for _, column := range columns {
+		if column == "" || column == "-" {
+			continue
+		}
+		found := false
+		for _, name := range Columns {
+			found = found || name == column
+		}
+		if !found {
+			return fmt.Errorf("未知的数据列: %q", column)
+		}
+	}
+	return nil
+}
+
+func (location *Location) setColumn(column, value string) {
+	switch column {
+	case "district":
+		location.District = value
+	case "owner":
+		location.Owner = value
+	case "country_code":
+		location.CountryCode = value
+	case "continent_code":
+		location.ContinentCode = value
+	case "latitude":
+		location.Latitude, _ = strconv.ParseFloat(value, 64)
+	case "longitude":
+		location.Longitude, _ = strconv.ParseFloat(value, 64)
+	case "timezone":
+		location.Timezone = value
+	case "utc_offset":
+		location.UTCOffset = value
+	case "admin_code":
+		location.AdminCode = value
+	case "idd_code":
+		location.IDDCode = value
+	}
 }
 
 func (location *Location) String() string {
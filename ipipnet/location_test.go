@@ -0,0 +1,68 @@
+package ipipnet
+
+import (
+	"encoding/json"
+	"net"
+	"reflect"
+	"testing"
+)
+
+func TestColumns(t *testing.T) {
+	text := "1.0.0.0\t1.0.0.255\t中国\t广东\t广州\t\t电信\t23.129163\t113.264435\tAsia/Shanghai\tUTC+8\t440100\t86\tCN\tAP\t\t天河\n"
+	locator, err := newTestLocator(t, []byte(text), "ipipnet.txt", func(config *Config) {
+		config.Columns = []string{"country", "province", "city", "owner", "isp", "latitude", "longitude",
+			"timezone", "utc_offset", "admin_code", "idd_code", "country_code", "continent_code", "-", "district"}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	location, err := locator.Locate(net.ParseIP("1.0.0.1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Location{
+		District:      "天河",
+		CountryCode:   "CN",
+		ContinentCode: "AP",
+		Latitude:      23.129163,
+		Longitude:     113.264435,
+		Timezone:      "Asia/Shanghai",
+		UTCOffset:     "UTC+8",
+		AdminCode:     "440100",
+		IDDCode:       "86",
+	}
+	if location.String() != "中国(1)/广东(2)/广州(3)/电信(10)" {
+		t.Errorf("Locate = %s", location)
+	}
+	extended := *location
+	extended.Country, extended.Province, extended.City, extended.ISPs = nil, nil, nil, nil
+	if !reflect.DeepEqual(extended, want) {
+		t.Errorf("Locate = %+v; want %+v", location, want)
+	}
+
+	if _, err := newTestLocator(t, []byte(text), "ipipnet.txt", func(config *Config) {
+		config.Columns = []string{"country", "zip"}
+	}); err == nil {
+		t.Error("New succeeded with unknown column")
+	}
+}
+
+func TestLegacyLocationJSON(t *testing.T) {
+	locator, err := newTestLocator(t, testData(), "ipipnet.dat", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	location, err := locator.Locate(net.ParseIP("1.0.0.1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := json.Marshal(location)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `{"country":{"id":1,"name":"中国"},"province":{"id":2,"name":"广东"},` +
+		`"city":{"id":3,"name":"广州"},"isps":[{"id":10,"name":"电信"}]}`
+	if string(data) != want {
+		t.Errorf("json = %s; want %s", data, want)
+	}
+}
@@ -1,225 +1,369 @@
 package ipipnet
 
 import (
-	"crypto/sha1"
+	"context"
 	"encoding/binary"
 	"errors"
 	"fmt"
-	"io"
 	"io/ioutil"
 	"net"
-	"net/http"
+	"net/netip"
 	"os"
-	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/yangchenxing/foochow/logging"
 )
 
-type section struct {
-	*Location
-	lower uint32
-	upper uint32
+type Config struct {
+	IDsPath              string
+	DataPath             string
+	DataURL              string
+	CheckInterval        time.Duration
+	DownloadTimeout      time.Duration
+	Proxy                string
+	Language             string
+	UnknownPlaceCallback func(string)
+	UnknownISPCallback   func(string)
+	// 自动更新时IPv4地址位置变化的数量和比例上限，超过时拒绝新数据，为0时不限制
+	MaxChangedAddresses uint64
+	MaxChangeRatio      float64
+	// 数据文本的列定义，可用的列名见Columns，为空时前三列为国家、省份、城市，最后一列为ISP
+	Columns []string
+	// IPv4查找范围的划分方式: "prefix8"（默认）按首字节划分；"prefix16"按前16位划分，多占512KB，查找更快
+	Index string
+	// 覆盖文件及其检查更新周期，周期为0时只能通过ReloadOverrides更新
+	OverridePath          string
+	OverrideCheckInterval time.Duration
+}
+
+var (
+	ErrDuplicatedDownload = errors.New("重复下载")
+	ErrNotIPv4            = errors.New("非IPv4地址且未加载IPv6数据")
+	ErrInvalidIP          = errors.New("无效IP地址")
+	ErrNotData            = errors.New("未加载数据")
+)
+
+// Locator 持有一份独立的ID表和IP数据，可以在同一进程中创建多个
+type Locator struct {
+	config           Config
+	data             atomic.Pointer[dataset]
+	loadLock         sync.Mutex
+	downloader       *Downloader
+	ctx              context.Context
+	cancel           context.CancelFunc
+	statusLock       sync.Mutex
+	status           Status
+	subscribers      []reloadSubscriber
+	nextSubscriberID int
+	refused          string
+	refusedErr       error
+	overrides        atomic.Pointer[overrideTable]
+	overrideLock     sync.Mutex
+}
+
+// dataset 是一次加载得到的不可变快照，ID表和索引总是一起发布
+type dataset struct {
+	ids           *idTable
+	index         *ipIndex
+	meta          *Metadata
+	unknownPlaces []UnknownName
+	unknownISPs   []UnknownName
 }
 
-type ipIndex struct {
-	sections []section
-	index    [256]struct {
-		lower int
-		upper int
+func New(config Config) (*Locator, error) {
+	if err := checkColumns(config.Columns); err != nil {
+		return nil, err
 	}
-	checksum string
+	if config.Index != "" && config.Index != "prefix8" && config.Index != "prefix16" {
+		return nil, fmt.Errorf("未知的索引类型: %q", config.Index)
+	}
+	// 新数据先下载到暂存路径，加载和检查通过后再替换数据文件
+	downloader, err := NewDownloader(config.DataURL, config.DataPath+".new", config.DownloadTimeout, config.Proxy)
+	if err != nil {
+		return nil, err
+	}
+	// 暂存文件在替换后不再存在，If-Modified-Since按当前数据文件计算
+	downloader.modTimePath = config.DataPath
+	locator := &Locator{
+		config:     config,
+		downloader: downloader,
+	}
+	locator.ctx, locator.cancel = context.WithCancel(context.Background())
+	if err := locator.loadData(); err != nil {
+		locator.Close()
+		return nil, err
+	}
+	if err := locator.ReloadOverrides(); err != nil {
+		locator.Close()
+		return nil, err
+	}
+	if config.OverridePath != "" && config.OverrideCheckInterval > 0 {
+		info, err := os.Stat(config.OverridePath)
+		if err != nil {
+			locator.Close()
+			return nil, fmt.Errorf("检查IP覆盖文件出错: path=%q, error=%q", config.OverridePath, err.Error())
+		}
+		go locator.watchOverrides(info.ModTime())
+	}
+	if config.DataURL != "" && config.CheckInterval > 0 {
+		go locator.autoReload()
+	}
+	return locator, nil
 }
 
-type Config struct {
-	IDsPath       string
-	DataPath      string
-	DataURL       string
-	CheckInterval time.Duration
+// Close 停止自动更新，并中断正在进行的下载
+func (locator *Locator) Close() {
+	locator.cancel()
 }
 
-var (
-	config struct {
-		idsPath       string
-		dataPath      string
-		dataURL       string
-		checkInterval time.Duration
+func (locator *Locator) Locate(ip net.IP) (*Location, error) {
+	addr, ok := netip.AddrFromSlice(ip)
+	if !ok {
+		return nil, ErrInvalidIP
 	}
-	index *ipIndex
+	return locator.LocateAddr(addr)
+}
 
-	ErrDuplicatedDownload = errors.New("重复下载")
-	ErrNotIPv4            = errors.New("非IPv4地址")
-	ErrNotData            = errors.New("未加载数据")
+// LocateAddr 查询地址，IPv4映射的IPv6地址按IPv4查询，不分配内存
+func (locator *Locator) LocateAddr(addr netip.Addr) (*Location, error) {
+	data := locator.data.Load()
+	if data == nil {
+		return nil, ErrNotData
+	}
+	return data.locate(locator.overrides.Load(), addr)
+}
 
-	UnknownPlaceCallback func(string)
-	UnknownISPCallback   func(string)
-)
+// LocateUint32 查询数值形式的IPv4地址，不分配内存
+func (locator *Locator) LocateUint32(ip uint32) (*Location, error) {
+	data := locator.data.Load()
+	if data == nil {
+		return nil, ErrNotData
+	}
+	if overrides := locator.overrides.Load(); overrides != nil {
+		if location := overrides.lookup4(ip); location != nil {
+			return location, nil
+		}
+	}
+	return data.index.locate(ip), nil
+}
 
-func Initialize(conf Config) error {
-	config.idsPath = conf.IDsPath
-	config.dataPath = conf.DataPath
-	config.dataURL = conf.DataURL
-	config.checkInterval = conf.CheckInterval
-	if err := loadIDs(); err != nil {
-		return err
+// LocateBatch 批量查询，结果按顺序写入results，整批使用同一份数据。
+// 无效或无法查询的地址结果为nil，results长度不足时返回错误
+func (locator *Locator) LocateBatch(addrs []netip.Addr, results []*Location) error {
+	if len(results) < len(addrs) {
+		return fmt.Errorf("结果数组长度不足: addrs=%d, results=%d", len(addrs), len(results))
 	}
-	if err := loadData(); err != nil {
-		return err
+	data := locator.data.Load()
+	if data == nil {
+		return ErrNotData
+	}
+	overrides := locator.overrides.Load()
+	for i, addr := range addrs {
+		results[i], _ = data.locate(overrides, addr)
 	}
-	go autoReload()
 	return nil
 }
 
-func Locate(ip net.IP) (*Location, error) {
-	if index == nil {
-		return nil, ErrNotData
+func (data *dataset) locate(overrides *overrideTable, addr netip.Addr) (*Location, error) {
+	if !addr.IsValid() {
+		return nil, ErrInvalidIP
 	}
-	if ip = ip.To4(); ip == nil {
-		return nil, ErrNotIPv4
+	addr = addr.Unmap()
+	if addr.Is4() {
+		a := addr.As4()
+		v := binary.BigEndian.Uint32(a[:])
+		if overrides != nil {
+			if location := overrides.lookup4(v); location != nil {
+				return location, nil
+			}
+		}
+		return data.index.locate(v), nil
+	}
+	a := addr.As16()
+	v := uint128{
+		hi: binary.BigEndian.Uint64(a[:8]),
+		lo: binary.BigEndian.Uint64(a[8:]),
 	}
-	v := binary.BigEndian.Uint32([]byte(ip))
-	idx := index
-	pos := index.index[v>>24]
-	for lower, upper := pos.lower, pos.upper; lower <= upper; {
-		mid := (lower + upper) / 2
-		section := idx.sections[mid]
-		if v < section.lower {
-			upper = mid - 1
-		} else if v > section.upper {
-			lower = mid + 1
-		} else {
-			return section.Location, nil
+	if overrides != nil {
+		if location := overrides.lookup6(v); location != nil {
+			return location, nil
 		}
 	}
-	return nil, nil
+	if len(data.index.sections6) == 0 {
+		return nil, ErrNotIPv4
+	}
+	return data.index.locate6(v), nil
 }
 
-func loadData() error {
-	if _, err := os.Lstat(config.dataPath); err != nil {
-		if err := download(); err != nil {
-			return fmt.Errorf("下载数据出错: %s", err.Error())
-		}
+// IPDBMetadata 返回当前.ipdb数据的元数据，其他格式返回nil
+func (locator *Locator) IPDBMetadata() *IPDBMetadata {
+	if data := locator.data.Load(); data != nil {
+		return data.index.ipdb
 	}
-	data, err := ioutil.ReadFile(config.dataPath)
-	if err != nil {
-		return fmt.Errorf("读取数据文件出错: path=%q, error=%q", config.dataPath, err.Error())
-	}
-	textOffset := binary.BigEndian.Uint32(data[:4]) - 1024
-	newIndex := &ipIndex{
-		sections: make([]section, (textOffset-4-1024)/8),
-	}
-	startIP := uint32(1)
-	for i, offset := 0, uint32(1028); offset < textOffset; i, offset = i+1, offset+8 {
-		endIP := binary.BigEndian.Uint32(data[offset : offset+4])
-		newIndex.sections[i].lower = startIP
-		newIndex.sections[i].upper = endIP
-		dataOffset := textOffset + (uint32(data[offset+4]) | uint32(data[offset+5])<<8 | uint32(data[offset+6])<<16)
-		dataLength := uint32(data[offset+7])
-		newIndex.sections[i].Location = getLocation(data[dataOffset : dataOffset+dataLength])
-		startIP = endIP + 1
-		newIndex.index[endIP>>24].upper = i
-	}
-	for i := 1; i < 256; i++ {
-		newIndex.index[i].lower = newIndex.index[i-1].upper + 1
-	}
-	newIndex.checksum = fmt.Sprintf("%x", sha1.Sum(data))
-	index = newIndex
-	logging.Info("加载IPIP.net数据完成: checksum=%s", index.checksum)
 	return nil
 }
 
-func autoReload() {
-	for {
-		logging.Debug("等待检查IPIP.net数据更新: %s", config.checkInterval)
-		time.Sleep(config.checkInterval)
-		if err := download(); err == ErrDuplicatedDownload {
-			continue
+// loadData 重新加载ID文件和数据文件，成功后替换当前快照，失败时保留原有数据
+func (locator *Locator) loadData() error {
+	locator.loadLock.Lock()
+	defer locator.loadLock.Unlock()
+	if _, err := os.Lstat(locator.config.DataPath); err != nil {
+		if err := locator.downloader.Download(locator.ctx, ""); err != nil {
+			return fmt.Errorf("下载数据出错: %s", err.Error())
 		}
-		if err := loadData(); err != nil {
-			logging.Error("加载IPIP.net新数据出错: %s", err.Error())
+		if err := os.Rename(locator.downloader.path, locator.config.DataPath); err != nil {
+			return fmt.Errorf("重命名数据文件出错: oldpath=%q, newpath=%q, error=%q",
+				locator.downloader.path, locator.config.DataPath, err.Error())
 		}
 	}
+	data, err := loadDataset(locator.config, locator.config.DataPath)
+	if err != nil {
+		return err
+	}
+	locator.store(data)
+	return nil
 }
 
-func download() error {
-	response, err := http.Get(config.dataURL)
+// reload 下载新数据并检查变化量，通过后替换数据文件和当前快照。
+// 被拒绝的数据留在下载路径中，在服务端数据再次变化前不会重复下载
+func (locator *Locator) reload() error {
+	locator.loadLock.Lock()
+	defer locator.loadLock.Unlock()
+	current := locator.data.Load()
+	checksum := current.meta.Checksum
+	if locator.refused != "" {
+		checksum = locator.refused
+	}
+	if err := locator.downloader.Download(locator.ctx, checksum); err != nil {
+		return err
+	}
+	data, err := loadDataset(locator.config, locator.downloader.path)
 	if err != nil {
 		return err
 	}
-	defer response.Body.Close()
-	etag := response.Header.Get("ETag")
-	if etag == "" {
-		return errors.New("HTTP应答头缺少ETag字段")
-	} else if !strings.HasPrefix(etag, "sha1-") {
-		return fmt.Errorf("不支持的ETag: %q", etag)
-	}
-	if index != nil && index.checksum == strings.ToLower(etag[5:]) {
-		return ErrDuplicatedDownload
-	}
-	if file, err := os.OpenFile(config.dataPath+".tmp", os.O_CREATE|os.O_WRONLY, 0755); err != nil {
-		return fmt.Errorf("打开临时文件出错: path=%q, error=%q", config.dataPath+".tmp", err.Error())
-	} else if _, err := io.Copy(file, response.Body); err != nil {
-		file.Close()
-		return fmt.Errorf("下载内容出错: path=%q, error=%q", config.dataPath+".tmp", err.Error())
-	} else {
-		file.Close()
-	}
-	if err := os.Rename(config.dataPath+".tmp", config.dataPath); err != nil {
-		return fmt.Errorf("重命名临时文件出错: oldpath=%q, newpath=%q, error=%q",
-			config.dataPath+".tmp", config.dataPath, err.Error())
-	}
-	logging.Debug("下载IPIP.net数据完成: checksum=%s", etag[5:])
+	if err := locator.checkChanges(current.index, data.index); err != nil {
+		locator.refused, locator.refusedErr = data.meta.Checksum, err
+		return err
+	}
+	locator.refused, locator.refusedErr = "", nil
+	if err := os.Rename(locator.downloader.path, locator.config.DataPath); err != nil {
+		return fmt.Errorf("重命名数据文件出错: oldpath=%q, newpath=%q, error=%q",
+			locator.downloader.path, locator.config.DataPath, err.Error())
+	}
+	locator.store(data)
+	// 覆盖表按新的ID表重新解析
+	if err := locator.ReloadOverrides(); err != nil {
+		logging.Error("更新IP覆盖文件出错: %s", err.Error())
+	}
 	return nil
 }
 
-func getLocation(data []byte) *Location {
-	fields := strings.Split(string(data), "\t")
-	placeNames := fields[:3]
-	ispNames := strings.Split(fields[len(fields)-1], "/")
-	location := new(Location)
-	// 获取地点
-	places := ids.places
-	for i, name := range placeNames {
-		if i > 2 || name == "" || (i == 1 && name == placeNames[0]) {
-			break
-		}
-		if len(places) == 0 {
-			break
+// checkChanges 按配置的阈值检查新数据中IPv4地址位置的变化量
+func (locator *Locator) checkChanges(oldIndex, newIndex *ipIndex) error {
+	if locator.config.MaxChangedAddresses == 0 && locator.config.MaxChangeRatio == 0 {
+		return nil
+	}
+	diff := diffIndex(oldIndex, newIndex)
+	if max := locator.config.MaxChangedAddresses; max > 0 && diff.Addresses > max {
+		return fmt.Errorf("新数据变化过大: addresses=%d, max=%d", diff.Addresses, max)
+	}
+	if max := locator.config.MaxChangeRatio; max > 0 && diff.Ratio > max {
+		return fmt.Errorf("新数据变化过大: ratio=%f, max=%f", diff.Ratio, max)
+	}
+	return nil
+}
+
+// store 发布新快照并通知订阅者
+func (locator *Locator) store(data *dataset) {
+	data.meta.Path = locator.config.DataPath
+	data.meta.URL = locator.config.DataURL
+	old := locator.data.Swap(data)
+	logging.Info("加载IPIP.net数据完成: checksum=%s, format=%s, sections=%d, sections6=%d",
+		data.meta.Checksum, data.meta.Format, data.meta.Sections, data.meta.Sections6)
+	if old != nil {
+		locator.notifyReload(old.meta, data.meta)
+	}
+}
+
+// loadDataset 加载ID文件和数据文件，生成新快照
+func loadDataset(config Config, path string) (*dataset, error) {
+	ids, err := loadIDs(config.IDsPath)
+	if err != nil {
+		return nil, fmt.Errorf("加载ID文件出错: path=%q, error=%q", config.IDsPath, err.Error())
+	}
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("读取数据文件出错: path=%q, error=%q", path, err.Error())
+	}
+	builder := newLocationBuilder(ids, config.UnknownPlaceCallback, config.UnknownISPCallback)
+	builder.columns = config.Columns
+	index, err := parse(content, builder, config.Language)
+	if err != nil {
+		return nil, fmt.Errorf("解析数据文件出错: path=%q, error=%q", path, err.Error())
+	}
+	if config.Index == "prefix16" {
+		index.buildIndex16()
+	}
+	meta := index.metadata()
+	meta.Path = path
+	meta.LoadTime = time.Now()
+	unknownPlaces, unknownISPs := builder.countUnknowns(index)
+	return &dataset{
+		ids:           ids,
+		index:         index,
+		meta:          meta,
+		unknownPlaces: unknownPlaces,
+		unknownISPs:   unknownISPs,
+	}, nil
+}
+
+// watchOverrides 定期检查覆盖文件的修改时间，有变化时重新加载，Close后停止
+func (locator *Locator) watchOverrides(modTime time.Time) {
+	ticker := time.NewTicker(locator.config.OverrideCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-locator.ctx.Done():
+			return
+		case <-ticker.C:
 		}
-		place, found := places[name]
-		if !found {
-			if key := strings.Join(placeNames[:+1], "/"); !ids.unknownPlaces[key] {
-				ids.unknownPlaces[key] = true
-				if UnknownPlaceCallback != nil {
-					UnknownPlaceCallback(key)
-				}
-			}
-			break
+		info, err := os.Stat(locator.config.OverridePath)
+		if err != nil || !modTime.Before(info.ModTime()) {
+			continue
 		}
-		switch i {
-		case 0:
-			location.Country = place.Place
-		case 1:
-			location.Province = place.Place
-		case 2:
-			location.City = place.Place
+		modTime = info.ModTime()
+		if err := locator.ReloadOverrides(); err != nil {
+			logging.Error("更新IP覆盖文件出错: %s", err.Error())
 		}
-		places = place.subplaces
 	}
-	location.ISPs = make([]*ISP, 0, len(ispNames))
-	for _, name := range ispNames {
-		if name == "" {
-			continue
-		}
-		isp := ids.isps[name]
-		if isp != nil {
-			location.ISPs = append(location.ISPs, isp)
-		} else {
-			ids.unknownISPs[name] = true
-			if UnknownISPCallback != nil {
-				UnknownISPCallback(name)
-			}
+}
+
+func (locator *Locator) autoReload() {
+	locator.statusLock.Lock()
+	locator.status.NextCheck = time.Now().Add(locator.config.CheckInterval)
+	locator.statusLock.Unlock()
+	for {
+		logging.Debug("等待检查IPIP.net数据更新: %s", locator.config.CheckInterval)
+		select {
+		case <-locator.ctx.Done():
+			return
+		case <-time.After(locator.config.CheckInterval):
 		}
+		locator.check()
+	}
+}
+
+// check 检查一次数据更新并记录结果，数据未更新时保留上次拒绝新数据的原因，直到有新数据被接受
+func (locator *Locator) check() {
+	err := locator.reload()
+	if err == ErrDuplicatedDownload {
+		locator.loadLock.Lock()
+		err = locator.refusedErr
+		locator.loadLock.Unlock()
+	} else if err != nil {
+		logging.Error("更新IPIP.net数据出错: %s", err.Error())
 	}
-	return location
+	locator.setCheckResult(err)
 }
@@ -0,0 +1,165 @@
+package ipipnet
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// newTestLocator 在临时目录写入testIDs和数据文件后创建Locator，data为nil时不写数据文件。
+// mutate在创建前修改配置，可用于写入其他文件，测试结束时关闭Locator
+func newTestLocator(t testing.TB, data []byte, fileName string, mutate func(*Config)) (*Locator, error) {
+	dir := t.TempDir()
+	config := Config{
+		IDsPath:  filepath.Join(dir, "ipipnet.ids"),
+		DataPath: filepath.Join(dir, fileName),
+	}
+	if err := ioutil.WriteFile(config.IDsPath, []byte(testIDs), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if data != nil {
+		if err := ioutil.WriteFile(config.DataPath, data, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if mutate != nil {
+		mutate(&config)
+	}
+	locator, err := New(config)
+	if err != nil {
+		return nil, err
+	}
+	t.Cleanup(locator.Close)
+	return locator, nil
+}
+
+func TestReloadKeepsIndex(t *testing.T) {
+	locator, err := newTestLocator(t, testData(), "ipipnet.dat", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(locator.config.DataPath, testData()[:1100], 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := locator.loadData(); err == nil {
+		t.Fatal("loadData succeeded on truncated file")
+	}
+	location, err := locator.Locate(net.ParseIP("1.2.3.4"))
+	if err != nil {
+		t.Fatalf("Locate error = %v", err)
+	}
+	if got, want := location.String(), "中国(1)/广东(2)/广州(3)/电信(10)"; got != want {
+		t.Errorf("Locate = %q; want %q", got, want)
+	}
+}
+
+func TestLocateDuringReload(t *testing.T) {
+	locator, err := newTestLocator(t, testData(), "ipipnet.dat", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var wg sync.WaitGroup
+	stop := make(chan struct{})
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				location, err := locator.Locate(net.ParseIP("1.2.3.4"))
+				if err != nil || location.GetCityID() != 3 {
+					t.Errorf("Locate = %v, %v; want city 3", location, err)
+					return
+				}
+			}
+		}()
+	}
+	for i := 0; i < 20; i++ {
+		if err := locator.loadData(); err != nil {
+			t.Errorf("loadData error = %v", err)
+		}
+	}
+	close(stop)
+	wg.Wait()
+}
+
+func TestOnReload(t *testing.T) {
+	locator, err := newTestLocator(t, testData(), "ipipnet.dat", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	first := locator.Metadata()
+	if first == nil || first.Format != "dat" || first.Sections != 3 {
+		t.Fatalf("Metadata = %+v", first)
+	}
+	var events [][2]*Metadata
+	cancel := locator.OnReload(func(old, new *Metadata) {
+		events = append(events, [2]*Metadata{old, new})
+	})
+	if err := locator.loadData(); err != nil {
+		t.Fatal(err)
+	}
+	cancel()
+	if err := locator.loadData(); err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 1 {
+		t.Fatalf("got %d events; want 1", len(events))
+	}
+	if events[0][0] != first || events[0][1].Checksum != first.Checksum {
+		t.Errorf("event = %+v", events[0])
+	}
+	if status := locator.Status(); status.Metadata != locator.Metadata() || !status.LastCheck.IsZero() {
+		t.Errorf("Status = %+v", status)
+	}
+}
+
+func TestStatusJSON(t *testing.T) {
+	server := newTestServer(testData())
+	defer server.Close()
+	locator, err := newTestLocator(t, nil, "ipipnet.dat", func(config *Config) {
+		config.DataURL = server.URL
+		config.CheckInterval = time.Hour
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 开启自动更新后尚未检查，只有下次检查时间
+	deadline := time.Now().Add(time.Second)
+	for locator.Status().NextCheck.IsZero() && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	status := locator.Status()
+	if !status.LastCheck.IsZero() || status.NextCheck.IsZero() || status.LastError != "" {
+		t.Errorf("Status = %+v", status)
+	}
+	data, err := json.Marshal(status)
+	if err != nil {
+		t.Fatal(err)
+	}
+	text := string(data)
+	if strings.Contains(text, "0001-01-01") || strings.Contains(text, "last_check") || strings.Contains(text, `"build"`) ||
+		!strings.Contains(text, `"next_check"`) || !strings.Contains(text, `"checksum"`) {
+		t.Errorf("Status JSON = %s", text)
+	}
+	locator.setCheckResult(errors.New("下载失败"))
+	if data, _ := json.Marshal(locator.Status()); !strings.Contains(string(data), `"last_error":"下载失败"`) ||
+		!strings.Contains(string(data), `"last_check"`) {
+		t.Errorf("Status JSON = %s", data)
+	}
+	meta := *locator.Metadata()
+	meta.Build = time.Date(2016, 1, 2, 0, 0, 0, 0, time.UTC)
+	if data, _ := json.Marshal(meta); !strings.Contains(string(data), `"build":"2016-01-02T00:00:00Z"`) {
+		t.Errorf("Metadata JSON = %s", data)
+	}
+}
@@ -0,0 +1,132 @@
+package ipipnet
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// Metadata 描述一次加载的数据
+type Metadata struct {
+	Checksum  string    `json:"checksum"`
+	Format    string    `json:"format"`
+	Path      string    `json:"path"`
+	URL       string    `json:"url,omitempty"`
+	LoadTime  time.Time `json:"load_time"`
+	Build     time.Time `json:"build,omitempty"` // 数据未记录构建时间时为零值，JSON中省略
+	Sections  int       `json:"sections"`
+	Sections6 int       `json:"sections6"`
+	IndexSize int       `json:"index_size"` // IPv4区段和查找范围占用的字节数
+}
+
+// MarshalJSON 省略零值的构建时间
+func (meta Metadata) MarshalJSON() ([]byte, error) {
+	type metadata Metadata
+	var build *time.Time
+	if !meta.Build.IsZero() {
+		build = &meta.Build
+	}
+	return json.Marshal(struct {
+		metadata
+		Build *time.Time `json:"build,omitempty"`
+	}{metadata(meta), build})
+}
+
+// Status 描述自动更新的状态，未开启自动更新时只有Metadata，尚未检查过时LastCheck为零值
+type Status struct {
+	Metadata  *Metadata `json:"metadata"`
+	LastCheck time.Time `json:"last_check,omitempty"`
+	LastError string    `json:"last_error,omitempty"`
+	NextCheck time.Time `json:"next_check,omitempty"`
+}
+
+// MarshalJSON 省略零值的检查时间
+func (status Status) MarshalJSON() ([]byte, error) {
+	type plainStatus Status
+	var lastCheck, nextCheck *time.Time
+	if !status.LastCheck.IsZero() {
+		lastCheck = &status.LastCheck
+	}
+	if !status.NextCheck.IsZero() {
+		nextCheck = &status.NextCheck
+	}
+	return json.Marshal(struct {
+		plainStatus
+		LastCheck *time.Time `json:"last_check,omitempty"`
+		NextCheck *time.Time `json:"next_check,omitempty"`
+	}{plainStatus(status), lastCheck, nextCheck})
+}
+
+type reloadSubscriber struct {
+	id       int
+	callback func(old, new *Metadata)
+}
+
+// OnReload 订阅数据更新事件，首次加载在New中完成，不会通知订阅者。返回的函数用于取消订阅
+func (locator *Locator) OnReload(callback func(old, new *Metadata)) func() {
+	locator.statusLock.Lock()
+	defer locator.statusLock.Unlock()
+	locator.nextSubscriberID++
+	id := locator.nextSubscriberID
+	locator.subscribers = append(locator.subscribers, reloadSubscriber{
+		id:       id,
+		callback: callback,
+	})
+	return func() {
+		locator.statusLock.Lock()
+		defer locator.statusLock.Unlock()
+		for i, subscriber := range locator.subscribers {
+			if subscriber.id == id {
+				locator.subscribers = append(locator.subscribers[:i:i], locator.subscribers[i+1:]...)
+				break
+			}
+		}
+	}
+}
+
+// Metadata 返回当前数据的元数据，未加载时返回nil
+func (locator *Locator) Metadata() *Metadata {
+	if data := locator.data.Load(); data != nil {
+		return data.meta
+	}
+	return nil
+}
+
+func (locator *Locator) Status() Status {
+	locator.statusLock.Lock()
+	defer locator.statusLock.Unlock()
+	status := locator.status
+	status.Metadata = locator.Metadata()
+	return status
+}
+
+func (locator *Locator) setCheckResult(err error) {
+	locator.statusLock.Lock()
+	defer locator.statusLock.Unlock()
+	now := time.Now()
+	locator.status.LastCheck = now
+	locator.status.LastError = ""
+	if err != nil {
+		locator.status.LastError = err.Error()
+	}
+	locator.status.NextCheck = now.Add(locator.config.CheckInterval)
+}
+
+func (locator *Locator) notifyReload(old, new *Metadata) {
+	locator.statusLock.Lock()
+	subscribers := locator.subscribers
+	locator.statusLock.Unlock()
+	for _, subscriber := range subscribers {
+		subscriber.callback(old, new)
+	}
+}
+
+func (index *ipIndex) metadata() *Metadata {
+	return &Metadata{
+		Checksum:  index.checksum,
+		Format:    index.format,
+		Build:     index.build,
+		Sections:  len(index.sections),
+		Sections6: len(index.sections6),
+		IndexSize: index.indexSize(),
+	}
+}
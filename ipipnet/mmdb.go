@@ -0,0 +1,475 @@
+package ipipnet
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"net"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	defaultMMDBLanguage = "zh-CN"
+
+	// 指针展开的最大层数，防止损坏的文件造成无限递归
+	mmdbMaxDepth = 64
+	// 解码一条数据最多展开的值数量，防止多个指针反复引用同一段数据造成指数级展开
+	mmdbMaxValues = 1 << 16
+)
+
+var (
+	mmdbMetadataMarker = []byte("\xab\xcd\xefMaxMind.com")
+
+	errMMDBOutOfRange = errors.New("MMDB数据越界")
+)
+
+// MMDBMetadata 是.mmdb文件末尾的元数据
+type MMDBMetadata struct {
+	DatabaseType string
+	Build        time.Time
+	IPVersion    int
+	RecordSize   int
+	NodeCount    int
+	Languages    []string
+	Description  map[string]string
+}
+
+type mmdb struct {
+	meta      MMDBMetadata
+	tree      []byte
+	data      []byte
+	ipv4Start int
+}
+
+func isMMDBData(data []byte) bool {
+	return bytes.LastIndex(data, mmdbMetadataMarker) >= 0
+}
+
+func openMMDB(buf []byte) (*mmdb, error) {
+	pos := bytes.LastIndex(buf, mmdbMetadataMarker)
+	if pos < 0 {
+		return nil, fmt.Errorf("缺少MMDB元数据标记")
+	}
+	value, _, err := (&mmdbDecoder{buf: buf[pos+len(mmdbMetadataMarker):]}).decode(0, 0)
+	if err != nil {
+		return nil, fmt.Errorf("解析MMDB元数据出错: %s", err.Error())
+	}
+	meta, ok := value.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("MMDB元数据不是map: %T", value)
+	}
+	db := new(mmdb)
+	if major := mmdbUint(meta["binary_format_major_version"]); major != 2 {
+		return nil, fmt.Errorf("不支持的MMDB版本: %d", major)
+	}
+	db.meta.NodeCount = int(mmdbUint(meta["node_count"]))
+	db.meta.RecordSize = int(mmdbUint(meta["record_size"]))
+	db.meta.IPVersion = int(mmdbUint(meta["ip_version"]))
+	db.meta.Build = time.Unix(int64(mmdbUint(meta["build_epoch"])), 0)
+	db.meta.DatabaseType, _ = meta["database_type"].(string)
+	if languages, ok := meta["languages"].([]interface{}); ok {
+		for _, language := range languages {
+			if language, ok := language.(string); ok {
+				db.meta.Languages = append(db.meta.Languages, language)
+			}
+		}
+	}
+	if description, ok := meta["description"].(map[string]interface{}); ok {
+		db.meta.Description = make(map[string]string)
+		for key, value := range description {
+			if value, ok := value.(string); ok {
+				db.meta.Description[key] = value
+			}
+		}
+	}
+	switch db.meta.RecordSize {
+	case 24, 28, 32:
+	default:
+		return nil, fmt.Errorf("不支持的MMDB记录长度: %d", db.meta.RecordSize)
+	}
+	if db.meta.IPVersion != 4 && db.meta.IPVersion != 6 {
+		return nil, fmt.Errorf("不支持的MMDB IP版本: %d", db.meta.IPVersion)
+	}
+	if db.meta.NodeCount <= 0 || db.meta.NodeCount > pos {
+		return nil, fmt.Errorf("MMDB节点数量无效: %d", db.meta.NodeCount)
+	}
+	treeSize := db.meta.RecordSize * 2 / 8 * db.meta.NodeCount
+	if treeSize+16 > pos {
+		return nil, fmt.Errorf("MMDB节点数量无效: %d", db.meta.NodeCount)
+	}
+	db.tree = buf[:treeSize]
+	db.data = buf[treeSize+16 : pos]
+	// IPv4地址位于::/96之下
+	if db.meta.IPVersion == 6 {
+		node := 0
+		for i := 0; i < 96 && node < db.meta.NodeCount; i++ {
+			node = db.readNode(node, 0)
+		}
+		db.ipv4Start = node
+	}
+	return db, nil
+}
+
+func (db *mmdb) readNode(node, bit int) int {
+	switch db.meta.RecordSize {
+	case 24:
+		b := db.tree[node*6+bit*3:]
+		return int(b[0])<<16 | int(b[1])<<8 | int(b[2])
+	case 28:
+		b := db.tree[node*7:]
+		if bit == 0 {
+			return int(b[3]&0xF0)<<20 | int(b[0])<<16 | int(b[1])<<8 | int(b[2])
+		}
+		return int(b[3]&0x0F)<<24 | int(b[4])<<16 | int(b[5])<<8 | int(b[6])
+	default:
+		return int(binary.BigEndian.Uint32(db.tree[node*8+bit*4:]))
+	}
+}
+
+// record 返回叶子节点指向的数据
+func (db *mmdb) record(node int) (interface{}, error) {
+	offset := node - db.meta.NodeCount - 16
+	if offset < 0 || offset >= len(db.data) {
+		return nil, errMMDBOutOfRange
+	}
+	value, _, err := (&mmdbDecoder{buf: db.data}).decode(offset, 0)
+	return value, err
+}
+
+func (db *mmdb) walker(resolve func(int) (*Location, error)) *trieWalker {
+	return &trieWalker{
+		nodeCount: db.meta.NodeCount,
+		readNode:  db.readNode,
+		resolve:   resolve,
+		v4Node:    db.ipv4Start,
+	}
+}
+
+// mmdbDecoder 解码MaxMind DB数据段，指针相对于buf起始位置，每个decoder只用于解码一条数据
+type mmdbDecoder struct {
+	buf    []byte
+	values int
+}
+
+func (decoder *mmdbDecoder) decode(offset, depth int) (interface{}, int, error) {
+	if depth > mmdbMaxDepth {
+		return nil, 0, fmt.Errorf("MMDB数据嵌套过深")
+	}
+	if decoder.values++; decoder.values > mmdbMaxValues {
+		return nil, 0, fmt.Errorf("MMDB数据展开的值超过%d个", mmdbMaxValues)
+	}
+	if offset >= len(decoder.buf) {
+		return nil, 0, errMMDBOutOfRange
+	}
+	ctrl := decoder.buf[offset]
+	offset++
+	typ := int(ctrl >> 5)
+	if typ == 1 {
+		pointer, next, err := decoder.pointer(ctrl, offset)
+		if err != nil {
+			return nil, 0, err
+		}
+		// 规范不允许指针指向指针
+		if pointer < len(decoder.buf) && decoder.buf[pointer]>>5 == 1 {
+			return nil, 0, fmt.Errorf("MMDB指针指向指针: offset=%d", pointer)
+		}
+		value, _, err := decoder.decode(pointer, depth+1)
+		return value, next, err
+	}
+	if typ == 0 {
+		if offset >= len(decoder.buf) {
+			return nil, 0, errMMDBOutOfRange
+		}
+		typ = 7 + int(decoder.buf[offset])
+		offset++
+	}
+	size := int(ctrl & 0x1f)
+	if size >= 29 {
+		n := size - 28
+		if offset+n > len(decoder.buf) {
+			return nil, 0, errMMDBOutOfRange
+		}
+		extra := 0
+		for _, b := range decoder.buf[offset : offset+n] {
+			extra = extra<<8 | int(b)
+		}
+		offset += n
+		switch n {
+		case 1:
+			size = 29 + extra
+		case 2:
+			size = 285 + extra
+		default:
+			size = 65821 + extra
+		}
+	}
+	switch typ {
+	case 7, 11:
+		// 每个元素至少占一个字节
+		if size > len(decoder.buf)-offset {
+			return nil, 0, errMMDBOutOfRange
+		}
+	}
+	switch typ {
+	case 7:
+		result := make(map[string]interface{}, size)
+		for i := 0; i < size; i++ {
+			key, next, err := decoder.decode(offset, depth+1)
+			if err != nil {
+				return nil, 0, err
+			}
+			name, ok := key.(string)
+			if !ok {
+				return nil, 0, fmt.Errorf("MMDB map键不是字符串: %T", key)
+			}
+			value, next, err := decoder.decode(next, depth+1)
+			if err != nil {
+				return nil, 0, err
+			}
+			result[name] = value
+			offset = next
+		}
+		return result, offset, nil
+	case 11:
+		result := make([]interface{}, 0, size)
+		for i := 0; i < size; i++ {
+			value, next, err := decoder.decode(offset, depth+1)
+			if err != nil {
+				return nil, 0, err
+			}
+			result = append(result, value)
+			offset = next
+		}
+		return result, offset, nil
+	case 14:
+		return size != 0, offset, nil
+	}
+	if offset+size > len(decoder.buf) {
+		return nil, 0, errMMDBOutOfRange
+	}
+	b := decoder.buf[offset : offset+size]
+	offset += size
+	switch typ {
+	case 2:
+		return string(b), offset, nil
+	case 3:
+		if size != 8 {
+			return nil, 0, fmt.Errorf("MMDB double长度错误: %d", size)
+		}
+		return math.Float64frombits(binary.BigEndian.Uint64(b)), offset, nil
+	case 4:
+		return append([]byte(nil), b...), offset, nil
+	case 5, 6, 9:
+		if size > 8 {
+			return nil, 0, fmt.Errorf("MMDB整数长度错误: %d", size)
+		}
+		v := uint64(0)
+		for _, x := range b {
+			v = v<<8 | uint64(x)
+		}
+		return v, offset, nil
+	case 8:
+		if size > 4 {
+			return nil, 0, fmt.Errorf("MMDB int32长度错误: %d", size)
+		}
+		v := uint32(0)
+		for _, x := range b {
+			v = v<<8 | uint32(x)
+		}
+		return int64(int32(v)), offset, nil
+	case 10:
+		// uint128保留原始字节
+		return append([]byte(nil), b...), offset, nil
+	case 15:
+		if size != 4 {
+			return nil, 0, fmt.Errorf("MMDB float长度错误: %d", size)
+		}
+		return float64(math.Float32frombits(binary.BigEndian.Uint32(b))), offset, nil
+	}
+	return nil, 0, fmt.Errorf("未知的MMDB数据类型: %d", typ)
+}
+
+func (decoder *mmdbDecoder) pointer(ctrl byte, offset int) (int, int, error) {
+	n := int(ctrl>>3&0x3) + 1
+	if offset+n > len(decoder.buf) {
+		return 0, 0, errMMDBOutOfRange
+	}
+	b := decoder.buf[offset : offset+n]
+	v := int(ctrl & 0x7)
+	if n == 4 {
+		v = 0
+	}
+	for _, x := range b {
+		v = v<<8 | int(x)
+	}
+	switch n {
+	case 2:
+		v += 2048
+	case 3:
+		v += 526336
+	}
+	return v, offset + n, nil
+}
+
+func mmdbUint(value interface{}) uint64 {
+	switch v := value.(type) {
+	case uint64:
+		return v
+	case int64:
+		return uint64(v)
+	}
+	return 0
+}
+
+// mmdbName 从names中取指定语言的名称，缺失时使用英文
+func mmdbName(value interface{}, language string) string {
+	record, _ := value.(map[string]interface{})
+	names, _ := record["names"].(map[string]interface{})
+	if name, ok := names[language].(string); ok {
+		return name
+	}
+	name, _ := names["en"].(string)
+	return name
+}
+
+// mmdbLocation 把GeoIP2/GeoLite2记录转换为Location，名称通过ID表映射
+func mmdbLocation(builder *locationBuilder, value interface{}, language string) *Location {
+	record, _ := value.(map[string]interface{})
+	var province string
+	if subdivisions, ok := record["subdivisions"].([]interface{}); ok && len(subdivisions) > 0 {
+		province = mmdbName(subdivisions[0], language)
+	}
+	isp, _ := record["isp"].(string)
+	if isp == "" {
+		isp, _ = record["autonomous_system_organization"].(string)
+	}
+	country, _ := record["country"].(map[string]interface{})
+	continent, _ := record["continent"].(map[string]interface{})
+	position, _ := record["location"].(map[string]interface{})
+	countryCode, _ := country["iso_code"].(string)
+	continentCode, _ := continent["code"].(string)
+	timezone, _ := position["time_zone"].(string)
+	location := builder.getLocationColumns([]string{mmdbName(record["country"], language), province,
+		mmdbName(record["city"], language), isp, countryCode, continentCode, timezone},
+		[]string{"country", "province", "city", "isp", "country_code", "continent_code", "timezone"})
+	location.Latitude, _ = position["latitude"].(float64)
+	location.Longitude, _ = position["longitude"].(float64)
+	return location
+}
+
+func parseMMDB(data []byte, builder *locationBuilder, language string) (*ipIndex, error) {
+	db, err := openMMDB(data)
+	if err != nil {
+		return nil, err
+	}
+	if language == "" || language == defaultLanguage {
+		language = defaultMMDBLanguage
+	}
+	locations := make(map[int]*Location)
+	walker := db.walker(func(node int) (*Location, error) {
+		if location, found := locations[node]; found {
+			return location, nil
+		}
+		value, err := db.record(node)
+		if err != nil {
+			return nil, err
+		}
+		location := mmdbLocation(builder, value, language)
+		locations[node] = location
+		return location, nil
+	})
+	newIndex := &ipIndex{
+		format: "mmdb",
+		build:  db.meta.Build,
+	}
+	if err := walker.walkIndex(newIndex, db.ipv4Start, db.meta.IPVersion == 6); err != nil {
+		return nil, err
+	}
+	newIndex.checksum = fmt.Sprintf("%x", sha1.Sum(data))
+	return newIndex, nil
+}
+
+// MMDBReader 直接在.mmdb文件上查询，不展开为区段索引
+type MMDBReader struct {
+	sync.Mutex
+	db        *mmdb
+	walker    *trieWalker
+	builder   *locationBuilder
+	language  string
+	locations map[int]*Location
+}
+
+// OpenMMDB 打开MaxMind DB文件，idsPath为ID文件路径，language为names中的语言，默认为zh-CN
+func OpenMMDB(path, idsPath, language string) (*MMDBReader, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("读取MMDB文件出错: path=%q, error=%q", path, err.Error())
+	}
+	ids, err := loadIDs(idsPath)
+	if err != nil {
+		return nil, err
+	}
+	db, err := openMMDB(data)
+	if err != nil {
+		return nil, err
+	}
+	if language == "" {
+		language = defaultMMDBLanguage
+	}
+	reader := &MMDBReader{
+		db:        db,
+		builder:   newLocationBuilder(ids, nil, nil),
+		language:  language,
+		locations: make(map[int]*Location),
+	}
+	reader.walker = db.walker(reader.resolve)
+	return reader, nil
+}
+
+func (reader *MMDBReader) Metadata() MMDBMetadata {
+	meta := reader.db.meta
+	meta.Languages = append([]string(nil), meta.Languages...)
+	sort.Strings(meta.Languages)
+	return meta
+}
+
+func (reader *MMDBReader) Locate(ip net.IP) (*Location, error) {
+	var node int
+	var err error
+	if ip4 := ip.To4(); ip4 != nil {
+		node, err = reader.walker.lookup(reader.db.ipv4Start, ip4)
+	} else if len(ip) != net.IPv6len {
+		return nil, ErrInvalidIP
+	} else if reader.db.meta.IPVersion == 4 {
+		return nil, ErrNotIPv4
+	} else {
+		node, err = reader.walker.lookup(0, ip)
+	}
+	if err != nil {
+		return nil, err
+	} else if node == reader.db.meta.NodeCount {
+		return nil, nil
+	}
+	return reader.resolve(node)
+}
+
+func (reader *MMDBReader) resolve(node int) (*Location, error) {
+	reader.Lock()
+	defer reader.Unlock()
+	if location, found := reader.locations[node]; found {
+		return location, nil
+	}
+	value, err := reader.db.record(node)
+	if err != nil {
+		return nil, err
+	}
+	location := mmdbLocation(reader.builder, value, reader.language)
+	reader.locations[node] = location
+	return location, nil
+}
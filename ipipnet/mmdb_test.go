@@ -0,0 +1,339 @@
+package ipipnet
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io/ioutil"
+	"math"
+	"net"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// testMMDB 在内存中构造MaxMind DB，子节点大于0为节点序号，0为空，小于0为数据偏移加1后取负
+type testMMDB struct {
+	nodes [][2]int
+	data  []byte
+}
+
+func newTestMMDB() *testMMDB {
+	return &testMMDB{nodes: [][2]int{{}}}
+}
+
+// prefix 把CIDR转换为IPv6前缀，IPv4位于::/96之下
+func (db *testMMDB) prefix(t *testing.T, cidr string) (net.IP, int) {
+	_, ipnet, err := net.ParseCIDR(cidr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ones, bits := ipnet.Mask.Size()
+	if bits == 32 {
+		return append(make(net.IP, 12), ipnet.IP.To4()...), ones + 96
+	}
+	return ipnet.IP.To16(), ones
+}
+
+// insert 把前缀的最后一位指向child，途经的节点不存在时创建
+func (db *testMMDB) insert(t *testing.T, cidr string, child int) {
+	ip, ones := db.prefix(t, cidr)
+	node := 0
+	for i := 0; i < ones; i++ {
+		bit := int(ip[i/8]>>uint(7-i%8)) & 1
+		if i == ones-1 {
+			db.nodes[node][bit] = child
+			return
+		}
+		if db.nodes[node][bit] == 0 {
+			db.nodes = append(db.nodes, [2]int{})
+			db.nodes[node][bit] = len(db.nodes) - 1
+		}
+		node = db.nodes[node][bit]
+	}
+}
+
+// node 返回前缀对应的内部节点
+func (db *testMMDB) node(t *testing.T, cidr string) int {
+	ip, ones := db.prefix(t, cidr)
+	node := 0
+	for i := 0; i < ones; i++ {
+		node = db.nodes[node][int(ip[i/8]>>uint(7-i%8))&1]
+	}
+	return node
+}
+
+func (db *testMMDB) append(value interface{}) int {
+	offset := len(db.data)
+	db.data = appendMMDBValue(db.data, value)
+	return offset
+}
+
+func (db *testMMDB) appendRaw(value []byte) int {
+	offset := len(db.data)
+	db.data = append(db.data, value...)
+	return offset
+}
+
+// testMMDBPointer 按指定长度编码指向offset的指针
+func testMMDBPointer(t *testing.T, size, offset int) []byte {
+	value := offset
+	switch size {
+	case 1:
+		value -= 2048
+	case 2:
+		value -= 526336
+	}
+	limits := []int{1 << 11, 1 << 19, 1 << 27, math.MaxInt32}
+	if value < 0 || value >= limits[size] {
+		t.Fatalf("pointer size %d cannot address offset %d", size, offset)
+	}
+	if size == 3 {
+		return binary.BigEndian.AppendUint32([]byte{1<<5 | 3<<3}, uint32(value))
+	}
+	result := []byte{byte(1<<5 | size<<3 | value>>(8*(size+1))&0x7)}
+	for i := size; i >= 0; i-- {
+		result = append(result, byte(value>>(8*i)))
+	}
+	return result
+}
+
+func (db *testMMDB) bytes(recordSize int, patch func(records [][2]uint32)) []byte {
+	nodeCount := len(db.nodes)
+	records := make([][2]uint32, nodeCount)
+	for i, node := range db.nodes {
+		for bit, child := range node {
+			switch {
+			case child > 0:
+				records[i][bit] = uint32(child)
+			case child == 0:
+				records[i][bit] = uint32(nodeCount)
+			default:
+				records[i][bit] = uint32(nodeCount + 16 - child - 1)
+			}
+		}
+	}
+	if patch != nil {
+		patch(records)
+	}
+	var result []byte
+	for _, record := range records {
+		left, right := record[0], record[1]
+		switch recordSize {
+		case 24:
+			result = append(result, byte(left>>16), byte(left>>8), byte(left), byte(right>>16), byte(right>>8), byte(right))
+		case 28:
+			result = append(result, byte(left>>16), byte(left>>8), byte(left), byte(left>>24<<4|right>>24&0x0F),
+				byte(right>>16), byte(right>>8), byte(right))
+		default:
+			result = binary.BigEndian.AppendUint32(result, left)
+			result = binary.BigEndian.AppendUint32(result, right)
+		}
+	}
+	result = append(result, make([]byte, 16)...)
+	result = append(result, db.data...)
+	result = append(result, mmdbMetadataMarker...)
+	return appendMMDBValue(result, map[string]interface{}{
+		"binary_format_major_version": uint64(2),
+		"binary_format_minor_version": uint64(0),
+		"build_epoch":                 uint64(1600000000),
+		"database_type":               "GeoIP2-City",
+		"description":                 map[string]interface{}{"en": "test"},
+		"ip_version":                  uint64(6),
+		"languages":                   []interface{}{"en", "zh-CN"},
+		"node_count":                  uint64(nodeCount),
+		"record_size":                 uint64(recordSize),
+	})
+}
+
+// buildTestMMDB 构造1.0.0.0/8和2001:db8::/32两个网段，::ffff:0:0/96指向IPv4子树。
+// pad为数据区开头的填充长度，用于产生超过24位的记录值；指针按pointerSize编码
+func buildTestMMDB(t *testing.T, recordSize, pad, pointerSize int) ([]byte, int) {
+	db := newTestMMDB()
+	if pad > 0 {
+		db.append(strings.Repeat("x", pad))
+	}
+	country := db.append(map[string]interface{}{
+		"iso_code": "CN",
+		"names":    map[string]interface{}{"en": "China", "zh-CN": "中国"},
+	})
+	isp := db.append("电信")
+	// map的值使用指针和扩展类型
+	var record bytes.Buffer
+	record.Write(appendMMDBControl(nil, 7, 6))
+	record.Write(appendMMDBValue(nil, "country"))
+	record.Write(testMMDBPointer(t, pointerSize, country))
+	record.Write(appendMMDBValue(nil, "isp"))
+	record.Write(testMMDBPointer(t, pointerSize, isp))
+	record.Write(appendMMDBValue(nil, "subdivisions"))
+	record.Write(appendMMDBValue(nil, []interface{}{
+		map[string]interface{}{"names": map[string]interface{}{"en": "Guangdong", "zh-CN": "广东"}},
+	}))
+	record.Write(appendMMDBValue(nil, "city"))
+	record.Write(appendMMDBValue(nil, map[string]interface{}{"names": map[string]interface{}{"en": "Guangzhou"}}))
+	record.Write(appendMMDBValue(nil, "location"))
+	record.Write(appendMMDBValue(nil, map[string]interface{}{"latitude": 23.5, "time_zone": "Asia/Shanghai"}))
+	record.Write(appendMMDBValue(nil, "extra"))
+	record.Write(appendMMDBControl(nil, 7, 6))
+	record.Write(appendMMDBValue(nil, "uint128"))
+	record.Write(append(appendMMDBControl(nil, 10, 16), bytes.Repeat([]byte{0xff}, 16)...))
+	record.Write(appendMMDBValue(nil, "int32"))
+	record.Write(binary.BigEndian.AppendUint32(appendMMDBControl(nil, 8, 4), uint32(0xfffffffb)))
+	record.Write(appendMMDBValue(nil, "float"))
+	record.Write(binary.BigEndian.AppendUint32(appendMMDBControl(nil, 15, 4), math.Float32bits(1.5)))
+	record.Write(appendMMDBValue(nil, "bool"))
+	record.Write(appendMMDBControl(nil, 14, 1))
+	record.Write(appendMMDBValue(nil, "bytes"))
+	record.Write(append(appendMMDBControl(nil, 4, 2), 1, 2))
+	record.Write(appendMMDBValue(nil, "uint16"))
+	record.Write(append(appendMMDBControl(nil, 5, 2), 1, 0))
+	offset := db.appendRaw(record.Bytes())
+	v6 := db.appendRaw(append(appendMMDBControl(nil, 7, 1), append(appendMMDBValue(nil, "country"),
+		testMMDBPointer(t, pointerSize, country)...)...))
+	db.insert(t, "1.0.0.0/8", -(offset + 1))
+	db.insert(t, "2001:db8::/32", -(v6 + 1))
+	db.insert(t, "::ffff:0:0/96", db.node(t, "0.0.0.0/0"))
+	return db.bytes(recordSize, nil), offset
+}
+
+func TestMMDB(t *testing.T) {
+	for _, test := range []struct {
+		recordSize  int
+		pad         int
+		pointerSize int
+	}{
+		{24, 0, 0},
+		{24, 4096, 1},
+		{28, 1 << 24, 2},
+		{32, 1 << 24, 3},
+	} {
+		data, offset := buildTestMMDB(t, test.recordSize, test.pad, test.pointerSize)
+		locator, err := newTestLocator(t, data, "test.mmdb", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		reader, err := OpenMMDB(locator.config.DataPath, locator.config.IDsPath, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		for ip, want := range map[string]string{
+			"1.2.3.4":        "中国(1)/广东(2)/电信(10)",
+			"::ffff:1.2.3.4": "中国(1)/广东(2)/电信(10)",
+			"2.0.0.1":        "<nil>",
+			"2001:db8::1":    "中国(1)",
+			"2001:db9::1":    "<nil>",
+		} {
+			for name, database := range map[string]Database{"locator": locator, "reader": reader} {
+				location, err := database.Locate(net.ParseIP(ip))
+				if err != nil {
+					t.Errorf("record size %d: %s.Locate(%s) error = %v", test.recordSize, name, ip, err)
+				} else if got := locationString(location); got != want {
+					t.Errorf("record size %d: %s.Locate(%s) = %s; want %s", test.recordSize, name, ip, got, want)
+				}
+			}
+		}
+		location, _ := reader.Locate(net.ParseIP("1.2.3.4"))
+		if location.CountryCode != "CN" || location.Latitude != 23.5 || location.Timezone != "Asia/Shanghai" {
+			t.Errorf("record size %d: location = %+v", test.recordSize, location)
+		}
+		// 别名子树不产生重复的区段
+		index := locator.data.Load().index
+		if len(index.sections) != 1 || len(index.sections6) != 1 {
+			t.Errorf("record size %d: sections = %d, sections6 = %d", test.recordSize, len(index.sections), len(index.sections6))
+		}
+		meta := reader.Metadata()
+		if meta.RecordSize != test.recordSize || meta.IPVersion != 6 || meta.DatabaseType != "GeoIP2-City" ||
+			meta.Build.Unix() != 1600000000 || !reflect.DeepEqual(meta.Languages, []string{"en", "zh-CN"}) {
+			t.Errorf("record size %d: metadata = %+v", test.recordSize, meta)
+		}
+		db, err := openMMDB(data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		value, err := db.record(db.meta.NodeCount + 16 + offset)
+		if err != nil {
+			t.Fatal(err)
+		}
+		extra, _ := value.(map[string]interface{})["extra"].(map[string]interface{})
+		want := map[string]interface{}{
+			"uint128": bytes.Repeat([]byte{0xff}, 16),
+			"int32":   int64(-5),
+			"float":   1.5,
+			"bool":    true,
+			"bytes":   []byte{1, 2},
+			"uint16":  uint64(256),
+		}
+		if !reflect.DeepEqual(extra, want) {
+			t.Errorf("record size %d: extra = %#v", test.recordSize, extra)
+		}
+	}
+}
+
+func TestMMDBInvalid(t *testing.T) {
+	build := func(record func(db *testMMDB) int, patch func(records [][2]uint32)) []byte {
+		db := newTestMMDB()
+		db.insert(t, "1.0.0.0/8", -(record(db) + 1))
+		return db.bytes(24, patch)
+	}
+	outOfRange := build(func(db *testMMDB) int {
+		return db.appendRaw(append(appendMMDBControl(nil, 7, 1), append(appendMMDBValue(nil, "country"),
+			testMMDBPointer(t, 0, 1000)...)...))
+	}, nil)
+	pointerToPointer := build(func(db *testMMDB) int {
+		return db.appendRaw(testMMDBPointer(t, 0, len(db.data)))
+	}, nil)
+	mapCycle := build(func(db *testMMDB) int {
+		return db.appendRaw(append(appendMMDBControl(nil, 7, 1), append(appendMMDBValue(nil, "country"),
+			testMMDBPointer(t, 0, len(db.data))...)...))
+	}, nil)
+	// 每层map的两个值都指向下一层，逐层展开需要2^30次解码
+	pointerDAG := build(func(db *testMMDB) int {
+		offset := db.append(map[string]interface{}{})
+		for i := 0; i < 30; i++ {
+			record := appendMMDBControl(nil, 7, 2)
+			for _, key := range []string{"a", "b"} {
+				record = append(appendMMDBValue(record, key), testMMDBPointer(t, 0, offset)...)
+			}
+			offset = db.appendRaw(record)
+		}
+		return offset
+	}, nil)
+	treeCycle := build(func(db *testMMDB) int {
+		return db.append(map[string]interface{}{})
+	}, func(records [][2]uint32) {
+		records[len(records)-1][0] = 0
+	})
+	for _, test := range []struct {
+		name string
+		data []byte
+		want string
+	}{
+		{"pointer out of range", outOfRange, errMMDBOutOfRange.Error()},
+		{"pointer to pointer", pointerToPointer, "MMDB指针指向指针"},
+		{"map cycle", mapCycle, "MMDB数据嵌套过深"},
+		{"pointer DAG", pointerDAG, "MMDB数据展开的值超过"},
+		{"tree cycle", treeCycle, "前缀树"},
+		{"truncated metadata", outOfRange[:len(outOfRange)-3], "解析MMDB元数据出错"},
+		{"truncated tree", outOfRange[len(outOfRange)-200:], "MMDB节点数量无效"},
+	} {
+		_, err := parseMMDB(test.data, newLocationBuilder(new(idTable), nil, nil), "")
+		if err == nil || !strings.Contains(err.Error(), test.want) {
+			t.Errorf("%s: parseMMDB error = %v; want %q", test.name, err, test.want)
+		}
+	}
+	path := filepath.Join(t.TempDir(), "test.mmdb")
+	if err := ioutil.WriteFile(path, pointerDAG, 0644); err != nil {
+		t.Fatal(err)
+	}
+	idsPath := filepath.Join(t.TempDir(), "ipipnet.ids")
+	if err := ioutil.WriteFile(idsPath, []byte(testIDs), 0644); err != nil {
+		t.Fatal(err)
+	}
+	reader, err := OpenMMDB(path, idsPath, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := reader.Locate(net.ParseIP("1.2.3.4")); err == nil {
+		t.Error("Locate succeeded on a pointer DAG")
+	}
+}
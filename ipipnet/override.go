@@ -0,0 +1,195 @@
+package ipipnet
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/yangchenxing/foochow/logging"
+)
+
+// overrideTable 是自定义的CIDR覆盖表，查询时按最长前缀匹配，优先于数据文件。
+// 覆盖文件每行一条记录，空行和#开头的行忽略，字段以Tab分隔，有两种写法:
+//
+//	10.0.0.0/8	中国	广东	广州	电信        名称，与数据文件相同，多个ISP以/分隔
+//	10.1.0.0/16	#3	#10/11                     地点ID和ISP ID，ISP可省略
+type overrideTable struct {
+	root4 *prefixNode
+	root6 *prefixNode
+	count int
+}
+
+type prefixNode struct {
+	children [2]*prefixNode
+	location *Location
+}
+
+// insert 插入网段，::ffff:0:0/96之下的网段按IPv4处理
+func (table *overrideTable) insert(ipnet *net.IPNet, location *Location) error {
+	ip := ipnet.IP.To4()
+	node := table.root4
+	if ip == nil {
+		ip = ipnet.IP.To16()
+		node = table.root6
+	}
+	ones, bits := ipnet.Mask.Size()
+	if ip != nil && len(ip) == net.IPv4len && bits == 128 {
+		ones -= 96
+	}
+	if ip == nil || ones < 0 || ones > len(ip)*8 {
+		return fmt.Errorf("不支持的网段: %s", ipnet)
+	}
+	for i := 0; i < ones; i++ {
+		bit := ip[i/8] >> uint(7-i%8) & 1
+		if node.children[bit] == nil {
+			node.children[bit] = new(prefixNode)
+		}
+		node = node.children[bit]
+	}
+	node.location = location
+	table.count++
+	return nil
+}
+
+func (table *overrideTable) lookup4(v uint32) *Location {
+	var location *Location
+	for i, node := 0, table.root4; node != nil; i++ {
+		if node.location != nil {
+			location = node.location
+		}
+		if i == 32 {
+			break
+		}
+		node = node.children[v>>uint(31-i)&1]
+	}
+	return location
+}
+
+func (table *overrideTable) lookup6(v uint128) *Location {
+	var location *Location
+	for i, node := 0, table.root6; node != nil; i++ {
+		if node.location != nil {
+			location = node.location
+		}
+		if i == 128 {
+			break
+		}
+		bits := v.hi
+		if i >= 64 {
+			bits = v.lo
+		}
+		node = node.children[bits>>uint(63-i%64)&1]
+	}
+	return location
+}
+
+func parseOverrides(data []byte, ids *idTable) (*overrideTable, error) {
+	table := &overrideTable{
+		root4: new(prefixNode),
+		root6: new(prefixNode),
+	}
+	builder := newLocationBuilder(ids, nil, nil)
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	lineNum := 0
+	for scanner.Scan() {
+		lineNum++
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || text[0] == '#' {
+			continue
+		}
+		fields := strings.SplitN(text, "\t", 2)
+		if len(fields) < 2 {
+			return nil, fmt.Errorf("错误的覆盖记录: line=%d, text=%q", lineNum, text)
+		}
+		_, ipnet, err := net.ParseCIDR(fields[0])
+		if err != nil {
+			return nil, fmt.Errorf("错误的覆盖网段: line=%d, text=%q, error=%q", lineNum, text, err.Error())
+		}
+		var location *Location
+		if strings.HasPrefix(fields[1], "#") {
+			location, err = overrideLocationByID(fields[1], ids)
+		} else {
+			places, isps := len(builder.unknownPlaces), len(builder.unknownISPs)
+			location = builder.getLocation([]byte(fields[1]))
+			if len(builder.unknownPlaces) != places || len(builder.unknownISPs) != isps {
+				err = fmt.Errorf("ID文件中没有此名称")
+			}
+		}
+		if err != nil {
+			return nil, fmt.Errorf("错误的覆盖记录: line=%d, text=%q, error=%q", lineNum, text, err.Error())
+		}
+		location.Override = true
+		if err := table.insert(ipnet, location); err != nil {
+			return nil, fmt.Errorf("错误的覆盖网段: line=%d, text=%q, error=%q", lineNum, text, err.Error())
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return table, nil
+}
+
+func overrideLocationByID(text string, ids *idTable) (*Location, error) {
+	fields := strings.Split(text, "\t")
+	location := &Location{
+		ISPs: make([]*ISP, 0),
+	}
+	if placeID := strings.TrimPrefix(fields[0], "#"); placeID != "" {
+		id, err := strconv.ParseUint(placeID, 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		path, found := ids.placePaths[id]
+		if !found {
+			return nil, fmt.Errorf("未知地点ID: %d", id)
+		}
+		for i, place := range path {
+			switch i {
+			case 0:
+				location.Country = place
+			case 1:
+				location.Province = place
+			case 2:
+				location.City = place
+			}
+		}
+	}
+	if len(fields) > 1 && fields[1] != "" {
+		for _, item := range strings.Split(fields[1], "/") {
+			id, err := strconv.ParseUint(strings.TrimPrefix(item, "#"), 10, 64)
+			if err != nil {
+				return nil, err
+			}
+			isp, found := ids.ispIDs[id]
+			if !found {
+				return nil, fmt.Errorf("未知ISP ID: %d", id)
+			}
+			location.ISPs = append(location.ISPs, isp)
+		}
+	}
+	return location, nil
+}
+
+// ReloadOverrides 重新加载覆盖文件，名称和ID按当前数据的ID表解析，失败时保留原有覆盖表
+func (locator *Locator) ReloadOverrides() error {
+	if locator.config.OverridePath == "" {
+		return nil
+	}
+	locator.overrideLock.Lock()
+	defer locator.overrideLock.Unlock()
+	content, err := ioutil.ReadFile(locator.config.OverridePath)
+	if err != nil {
+		return fmt.Errorf("读取覆盖文件出错: path=%q, error=%q", locator.config.OverridePath, err.Error())
+	}
+	table, err := parseOverrides(content, locator.data.Load().ids)
+	if err != nil {
+		return fmt.Errorf("解析覆盖文件出错: path=%q, error=%q", locator.config.OverridePath, err.Error())
+	}
+	locator.overrides.Store(table)
+	logging.Info("加载IP覆盖文件完成: path=%s, count=%d", locator.config.OverridePath, table.count)
+	return nil
+}
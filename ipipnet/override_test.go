@@ -0,0 +1,141 @@
+package ipipnet
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testOverrides = "# 办公网\n" +
+	"10.0.0.0/8\t中国\t广东\t\t\t\n" +
+	"10.1.0.0/16\t#3\t#10\n" +
+	"1.0.0.128/25\t#1\n" +
+	"2001:db8::/32\t中国\t广东\t广州\t\t电信\n"
+
+// withOverrides 返回写入覆盖文件的配置修改，用于newTestLocator
+func withOverrides(t *testing.T, overrides string, interval time.Duration) func(*Config) {
+	return func(config *Config) {
+		config.OverridePath = filepath.Join(filepath.Dir(config.DataPath), "override.txt")
+		config.OverrideCheckInterval = interval
+		if err := ioutil.WriteFile(config.OverridePath, []byte(overrides), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestOverride(t *testing.T) {
+	locator, err := newTestLocator(t, testData(), "ipipnet.dat", withOverrides(t, testOverrides, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, test := range []struct {
+		ip       string
+		want     string
+		override bool
+	}{
+		{"10.2.0.1", "中国(1)/广东(2)", true},
+		{"10.1.0.1", "中国(1)/广东(2)/广州(3)/电信(10)", true},
+		{"1.0.0.1", "中国(1)/广东(2)/广州(3)/电信(10)", false},
+		{"1.0.0.200", "中国(1)", true},
+		{"2001:db8::1", "中国(1)/广东(2)/广州(3)/电信(10)", true},
+	} {
+		location, err := locator.Locate(net.ParseIP(test.ip))
+		if err != nil {
+			t.Errorf("Locate(%s) error = %v", test.ip, err)
+		} else if location.String() != test.want || location.Override != test.override {
+			t.Errorf("Locate(%s) = %s, override=%v; want %s, override=%v",
+				test.ip, location, location.Override, test.want, test.override)
+		}
+	}
+	if _, err := locator.Locate(net.ParseIP("2001:db9::1")); err != ErrNotIPv4 {
+		t.Errorf("Locate(2001:db9::1) error = %v; want ErrNotIPv4", err)
+	}
+
+	if err := ioutil.WriteFile(locator.config.OverridePath, []byte("10.0.0.0/8\t#1\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := locator.ReloadOverrides(); err != nil {
+		t.Fatal(err)
+	}
+	if location, _ := locator.Locate(net.ParseIP("10.1.0.1")); location.String() != "中国(1)" {
+		t.Errorf("after reload Locate(10.1.0.1) = %s", location)
+	}
+	if err := ioutil.WriteFile(locator.config.OverridePath, []byte("bad\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := locator.ReloadOverrides(); err == nil {
+		t.Error("ReloadOverrides succeeded on invalid file")
+	}
+	if location, _ := locator.Locate(net.ParseIP("10.1.0.1")); location.String() != "中国(1)" {
+		t.Errorf("failed reload replaced overrides: Locate(10.1.0.1) = %s", location)
+	}
+}
+
+func TestOverrideInvalid(t *testing.T) {
+	for _, text := range []string{
+		"10.0.0.0\t中国\n",
+		"10.0.0.0/8\n",
+		"10.0.0.0/8\t美国\n",
+		"10.0.0.0/8\t中国\t\t\t\t联通\n",
+		"10.0.0.0/8\t#99\n",
+		"10.0.0.0/8\t#1\t#99\n",
+		"10.0.0.0/8\t#x\n",
+	} {
+		if _, err := newTestLocator(t, testData(), "ipipnet.dat", withOverrides(t, text, 0)); err == nil || !strings.Contains(err.Error(), "覆盖") {
+			t.Errorf("override %q: error = %v; want override error", text, err)
+		}
+	}
+}
+
+func TestOverrideMappedIPv4(t *testing.T) {
+	locator, err := newTestLocator(t, testData(), "ipipnet.dat", withOverrides(t, "::ffff:10.0.0.0/104\t#1\n", 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, ip := range []string{"10.1.2.3", "::ffff:10.1.2.3"} {
+		if location, err := locator.Locate(net.ParseIP(ip)); err != nil || location.String() != "中国(1)" || !location.Override {
+			t.Errorf("Locate(%s) = %v, %v; want override 中国(1)", ip, location, err)
+		}
+	}
+	if location, _ := locator.Locate(net.ParseIP("11.0.0.1")); location != nil && location.Override {
+		t.Errorf("Locate(11.0.0.1) = %s; want no override", location)
+	}
+}
+
+func TestOverrideWatch(t *testing.T) {
+	locator, err := newTestLocator(t, testData(), "ipipnet.dat", withOverrides(t, "10.0.0.0/8\t#1\n", 10*time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	update := func(text string, modTime time.Time) {
+		if err := ioutil.WriteFile(locator.config.OverridePath, []byte(text), 0644); err != nil {
+			t.Fatal(err)
+		} else if err := os.Chtimes(locator.config.OverridePath, modTime, modTime); err != nil {
+			t.Fatal(err)
+		}
+	}
+	wait := func(want string) {
+		deadline := time.Now().Add(time.Second)
+		for {
+			location, _ := locator.Locate(net.ParseIP("10.1.0.1"))
+			if location.String() == want {
+				return
+			} else if time.Now().After(deadline) {
+				t.Fatalf("Locate(10.1.0.1) = %s; want %s", location, want)
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+	}
+	update("10.0.0.0/8\t#2\n", time.Now().Add(time.Hour))
+	wait("中国(1)/广东(2)")
+	// Close后不再检查覆盖文件
+	locator.Close()
+	time.Sleep(30 * time.Millisecond)
+	update("10.0.0.0/8\t#3\n", time.Now().Add(2*time.Hour))
+	time.Sleep(50 * time.Millisecond)
+	wait("中国(1)/广东(2)")
+}
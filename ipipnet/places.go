@@ -0,0 +1,102 @@
+package ipipnet
+
+import (
+	"sort"
+)
+
+// 以下接口查询当前数据的ID表，ID表随数据一起更新
+
+// PlaceByID 按ID查询地点，不存在时返回nil
+func (locator *Locator) PlaceByID(id uint64) *Place {
+	return locator.data.Load().ids.placeIDs[id].Place
+}
+
+// PlaceByPath 按名称路径查询地点，例如PlaceByPath("中国", "广东", "广州")
+func (locator *Locator) PlaceByPath(names ...string) *Place {
+	places := locator.data.Load().ids.places
+	var place placeTree
+	for _, name := range names {
+		var found bool
+		if place, found = places[name]; !found {
+			return nil
+		}
+		places = place.subplaces
+	}
+	return place.Place
+}
+
+// PlacePath 返回从国家到该地点的地点链，地点不存在时返回nil
+func (locator *Locator) PlacePath(id uint64) []*Place {
+	path := locator.data.Load().ids.placePaths[id]
+	return append([]*Place(nil), path...)
+}
+
+// Parent 返回上级地点，国家或不存在的地点返回nil
+func (locator *Locator) Parent(id uint64) *Place {
+	if path := locator.data.Load().ids.placePaths[id]; len(path) > 1 {
+		return path[len(path)-2]
+	}
+	return nil
+}
+
+// Children 返回下级地点，按ID排序。id为0时返回全部国家
+func (locator *Locator) Children(id uint64) []*Place {
+	ids := locator.data.Load().ids
+	places := ids.places
+	if id != 0 {
+		places = ids.placeIDs[id].subplaces
+	}
+	return sortedPlaces(places)
+}
+
+// WalkPlaces 按ID顺序深度优先遍历全部地点，path为从国家到当前地点的地点链，fn返回false时停止
+func (locator *Locator) WalkPlaces(fn func(path []*Place) bool) {
+	var walk func(places map[string]placeTree, parents []*Place) bool
+	walk = func(places map[string]placeTree, parents []*Place) bool {
+		for _, place := range sortedPlaces(places) {
+			path := append(parents[:len(parents):len(parents)], place)
+			if !fn(path) {
+				return false
+			}
+			if !walk(places[place.Name].subplaces, path) {
+				return false
+			}
+		}
+		return true
+	}
+	walk(locator.data.Load().ids.places, nil)
+}
+
+// ISPByID 按ID查询ISP，不存在时返回nil
+func (locator *Locator) ISPByID(id uint64) *ISP {
+	return locator.data.Load().ids.ispIDs[id]
+}
+
+// ISPByName 按名称查询ISP，不存在时返回nil
+func (locator *Locator) ISPByName(name string) *ISP {
+	return locator.data.Load().ids.isps[name]
+}
+
+// ISPs 返回全部ISP，按ID排序
+func (locator *Locator) ISPs() []*ISP {
+	isps := locator.data.Load().ids.isps
+	result := make([]*ISP, 0, len(isps))
+	for _, isp := range isps {
+		result = append(result, isp)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].ID < result[j].ID
+	})
+	return result
+}
+
+func sortedPlaces(places map[string]placeTree) []*Place {
+	result := make([]*Place, 0, len(places))
+	for _, place := range places {
+		result = append(result, place.Place)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].ID < result[j].ID
+	})
+	return result
+}
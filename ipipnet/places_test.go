@@ -0,0 +1,105 @@
+package ipipnet
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func placeNames(places []*Place) string {
+	names := make([]string, len(places))
+	for i, place := range places {
+		names[i] = place.Name
+	}
+	return strings.Join(names, "/")
+}
+
+func TestPlaces(t *testing.T) {
+	locator, err := newTestLocator(t, testData(), "ipipnet.dat", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if place := locator.PlaceByID(2); place == nil || place.Name != "广东" {
+		t.Errorf("PlaceByID(2) = %v", place)
+	}
+	if place := locator.PlaceByID(99); place != nil {
+		t.Errorf("PlaceByID(99) = %v; want nil", place)
+	}
+	if place := locator.PlaceByPath("中国", "广东", "广州"); place == nil || place.ID != 3 {
+		t.Errorf("PlaceByPath = %v", place)
+	}
+	if place := locator.PlaceByPath("中国", "广西"); place != nil {
+		t.Errorf("PlaceByPath(中国, 广西) = %v; want nil", place)
+	}
+	if got := placeNames(locator.PlacePath(3)); got != "中国/广东/广州" {
+		t.Errorf("PlacePath(3) = %s", got)
+	}
+	if parent := locator.Parent(3); parent == nil || parent.ID != 2 {
+		t.Errorf("Parent(3) = %v", parent)
+	}
+	if parent := locator.Parent(1); parent != nil {
+		t.Errorf("Parent(1) = %v; want nil", parent)
+	}
+	if got := placeNames(locator.Children(0)); got != "中国" {
+		t.Errorf("Children(0) = %s", got)
+	}
+	if got := placeNames(locator.Children(2)); got != "广州" {
+		t.Errorf("Children(2) = %s", got)
+	}
+	var paths []string
+	locator.WalkPlaces(func(path []*Place) bool {
+		paths = append(paths, placeNames(path))
+		return true
+	})
+	if got := strings.Join(paths, ","); got != "中国,中国/广东,中国/广东/广州" {
+		t.Errorf("WalkPlaces = %s", got)
+	}
+	if isp := locator.ISPByID(10); isp == nil || isp.Name != "电信" {
+		t.Errorf("ISPByID(10) = %v", isp)
+	}
+	if isp := locator.ISPByName("电信"); isp == nil || isp.ID != 10 {
+		t.Errorf("ISPByName = %v", isp)
+	}
+	if isps := locator.ISPs(); len(isps) != 1 || isps[0].ID != 10 {
+		t.Errorf("ISPs = %v", isps)
+	}
+}
+
+func TestDuplicateIDs(t *testing.T) {
+	for _, test := range []struct {
+		ids  string
+		want string
+	}{
+		{testIDs + "region,中国,广西,2\n", `重复的地域ID: line=5, path="中国/广西", id=2, previous="中国/广东"`},
+		{testIDs + "isp,联通,10\n", `重复的ISP ID: line=5, name="联通", id=10, previous="电信"`},
+	} {
+		path := filepath.Join(t.TempDir(), "ipipnet.ids")
+		if err := ioutil.WriteFile(path, []byte(test.ids), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := loadIDs(path); err == nil || err.Error() != test.want {
+			t.Errorf("loadIDs error = %v; want %s", err, test.want)
+		}
+	}
+}
+
+func TestIDRecordWithoutName(t *testing.T) {
+	for _, test := range []struct {
+		ids  string
+		want string
+	}{
+		{testIDs + "region,5\n", `错误的地域ID记录: line=5, text="region,5"`},
+		{testIDs + "region,,5\n", `错误的地域ID记录: line=5, text="region,,5"`},
+		{testIDs + "isp,11\n", `错误的ISP ID记录: line=5, text="isp,11"`},
+		{testIDs + "isp,,11\n", `错误的ISP ID记录: line=5, text="isp,,11"`},
+	} {
+		path := filepath.Join(t.TempDir(), "ipipnet.ids")
+		if err := ioutil.WriteFile(path, []byte(test.ids), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := loadIDs(path); err == nil || err.Error() != test.want {
+			t.Errorf("loadIDs error = %v; want %s", err, test.want)
+		}
+	}
+}
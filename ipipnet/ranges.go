@@ -0,0 +1,143 @@
+package ipipnet
+
+import (
+	"math/bits"
+	"net"
+)
+
+// RangeFilter 反查条件，为0的ID不参与匹配
+type RangeFilter struct {
+	CountryID  uint64
+	ProvinceID uint64
+	CityID     uint64
+	ISPID      uint64
+}
+
+func (filter RangeFilter) match(location *Location) bool {
+	if location == nil {
+		return false
+	}
+	if filter.CountryID != 0 && location.GetCountryID() != filter.CountryID {
+		return false
+	}
+	if filter.ProvinceID != 0 && location.GetProvinceID() != filter.ProvinceID {
+		return false
+	}
+	if filter.CityID != 0 && location.GetCityID() != filter.CityID {
+		return false
+	}
+	if filter.ISPID != 0 {
+		for _, isp := range location.ISPs {
+			if isp.ID == filter.ISPID {
+				return true
+			}
+		}
+		return false
+	}
+	return true
+}
+
+// Ranges 返回满足条件的全部地址段，相邻的区段合并后拆分为最少的CIDR，IPv4在前
+func (locator *Locator) Ranges(filter RangeFilter) []*net.IPNet {
+	return locator.data.Load().index.ranges(filter)
+}
+
+func (index *ipIndex) ranges(filter RangeFilter) []*net.IPNet {
+	var result []*net.IPNet
+	var intervals []interval
+	for _, section := range index.sections {
+		if filter.match(section.Location) {
+			intervals = appendInterval(intervals, uint128{lo: uint64(section.lower)}, uint128{lo: uint64(section.upper)})
+		}
+	}
+	for _, interval := range intervals {
+		result = appendCIDRs(result, interval.lower, interval.upper, 32)
+	}
+	intervals = intervals[:0]
+	for _, section := range index.sections6 {
+		if filter.match(section.Location) {
+			intervals = appendInterval(intervals, section.lower, section.upper)
+		}
+	}
+	for _, interval := range intervals {
+		result = appendCIDRs(result, interval.lower, interval.upper, 128)
+	}
+	return result
+}
+
+type interval struct {
+	lower uint128
+	upper uint128
+}
+
+// appendInterval 追加区段，与上一区段相连时合并
+func appendInterval(intervals []interval, lower, upper uint128) []interval {
+	if n := len(intervals); n > 0 && intervals[n-1].upper.add1() == lower && lower != (uint128{}) {
+		intervals[n-1].upper = upper
+		return intervals
+	}
+	return append(intervals, interval{lower: lower, upper: upper})
+}
+
+// appendCIDRs 把[lower, upper]拆分为CIDR，size为地址位数
+func appendCIDRs(result []*net.IPNet, lower, upper uint128, size int) []*net.IPNet {
+	for {
+		k := lower.trailingZeros()
+		if k > size {
+			k = size
+		}
+		for k > 0 && upper.less(lower.fill(k)) {
+			k--
+		}
+		last := lower.fill(k)
+		result = append(result, &net.IPNet{
+			IP:   lower.toIP(size),
+			Mask: net.CIDRMask(size-k, size),
+		})
+		if last == upper {
+			return result
+		}
+		lower = last.add1()
+	}
+}
+
+func (v uint128) add1() uint128 {
+	v.lo++
+	if v.lo == 0 {
+		v.hi++
+	}
+	return v
+}
+
+func (v uint128) trailingZeros() int {
+	if v.lo != 0 {
+		return bits.TrailingZeros64(v.lo)
+	}
+	return 64 + bits.TrailingZeros64(v.hi)
+}
+
+// fill 把低k位置为1
+func (v uint128) fill(k int) uint128 {
+	if k >= 64 {
+		v.lo = ^uint64(0)
+		v.hi |= 1<<uint(k-64) - 1
+	} else {
+		v.lo |= 1<<uint(k) - 1
+	}
+	return v
+}
+
+// Walk 按地址顺序遍历全部区段，IPv4在前，fn返回false时停止
+func (locator *Locator) Walk(fn func(start, end net.IP, location *Location) bool) {
+	index := locator.data.Load().index
+	for _, section := range index.sections {
+		if !fn(uint128{lo: uint64(section.lower)}.toIP(32), uint128{lo: uint64(section.upper)}.toIP(32), section.Location) {
+			return
+		}
+	}
+	for _, section := range index.sections6 {
+		if !fn(section.lower.toIP(128), section.upper.toIP(128), section.Location) {
+			return
+		}
+	}
+}
@@ -0,0 +1,56 @@
+package ipipnet
+
+import (
+	"net"
+	"reflect"
+	"testing"
+)
+
+func cidrStrings(nets []*net.IPNet) []string {
+	var result []string
+	for _, n := range nets {
+		result = append(result, n.String())
+	}
+	return result
+}
+
+func TestRanges(t *testing.T) {
+	locator, err := newTestLocator(t, testData(), "ipipnet.dat", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, test := range []struct {
+		filter RangeFilter
+		want   []string
+	}{
+		{RangeFilter{CityID: 3}, []string{"1.0.0.0/8"}},
+		{RangeFilter{ProvinceID: 2, ISPID: 10}, []string{"1.0.0.0/8"}},
+		{RangeFilter{CityID: 3, ISPID: 11}, nil},
+		{RangeFilter{CountryID: 1}, []string{
+			"1.0.0.0/8", "2.0.0.0/7", "4.0.0.0/6", "8.0.0.0/5",
+			"16.0.0.0/4", "32.0.0.0/3", "64.0.0.0/2", "128.0.0.0/1",
+		}},
+	} {
+		if got := cidrStrings(locator.Ranges(test.filter)); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("Ranges(%+v) = %v; want %v", test.filter, got, test.want)
+		}
+	}
+}
+
+func TestAppendCIDRs(t *testing.T) {
+	for _, test := range []struct {
+		lower, upper uint128
+		size         int
+		want         []string
+	}{
+		{uint128{lo: 0x0A000001}, uint128{lo: 0x0A000006}, 32,
+			[]string{"10.0.0.1/32", "10.0.0.2/31", "10.0.0.4/31", "10.0.0.6/32"}},
+		{uint128{}, uint128{lo: 0xFFFFFFFF}, 32, []string{"0.0.0.0/0"}},
+		{uint128{}, uint128{^uint64(0), ^uint64(0)}, 128, []string{"::/0"}},
+		{uint128{hi: 0x2001 << 48}, uint128{hi: 0x2001<<48 | 1, lo: ^uint64(0)}, 128, []string{"2001::/63"}},
+	} {
+		if got := cidrStrings(appendCIDRs(nil, test.lower, test.upper, test.size)); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("appendCIDRs(%v, %v) = %v; want %v", test.lower, test.upper, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,92 @@
+package ipipnet
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha1"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+)
+
+// 文本格式不是ipip.net发布的数据格式，只用于测试数据和自行转换导入的区段数据，
+// ipip.net的IPv6数据以IPDB格式发布，见ipdb.go。
+// 每行一个区段: 起始IP\t结束IP\t国家\t省份\t城市\t...\tISP，
+// IPv4和IPv6区段可以混合出现
+func isTextData(data []byte) bool {
+	line := data
+	if i := bytes.IndexByte(data, '\n'); i >= 0 {
+		line = data[:i]
+	}
+	fields := strings.SplitN(strings.TrimRight(string(line), "\r"), "\t", 3)
+	return len(fields) == 3 && net.ParseIP(fields[0]) != nil && net.ParseIP(fields[1]) != nil
+}
+
+func parseText(data []byte, builder *locationBuilder) (*ipIndex, error) {
+	newIndex := new(ipIndex)
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	lineNum := 0
+	for scanner.Scan() {
+		lineNum++
+		text := strings.TrimRight(scanner.Text(), "\r")
+		if text == "" {
+			continue
+		}
+		fields := strings.SplitN(text, "\t", 3)
+		if len(fields) != 3 {
+			return nil, fmt.Errorf("错误的区段记录: line=%d, text=%q", lineNum, text)
+		}
+		lower, upper := net.ParseIP(fields[0]), net.ParseIP(fields[1])
+		if lower == nil || upper == nil {
+			return nil, fmt.Errorf("错误的区段地址: line=%d, text=%q", lineNum, text)
+		}
+		location := builder.getLocation([]byte(fields[2]))
+		if lower4, upper4 := lower.To4(), upper.To4(); lower4 != nil && upper4 != nil {
+			if ipToUint32(lower4) > ipToUint32(upper4) {
+				return nil, fmt.Errorf("区段起始地址大于结束地址: line=%d, text=%q", lineNum, text)
+			}
+			newIndex.sections = append(newIndex.sections, section{
+				Location: location,
+				lower:    ipToUint32(lower4),
+				upper:    ipToUint32(upper4),
+			})
+		} else if lower4 == nil && upper4 == nil {
+			if uint128FromIP(upper).less(uint128FromIP(lower)) {
+				return nil, fmt.Errorf("区段起始地址大于结束地址: line=%d, text=%q", lineNum, text)
+			}
+			newIndex.sections6 = append(newIndex.sections6, section6{
+				Location: location,
+				lower:    uint128FromIP(lower),
+				upper:    uint128FromIP(upper),
+			})
+		} else {
+			return nil, fmt.Errorf("区段地址族不一致: line=%d, text=%q", lineNum, text)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	sort.Slice(newIndex.sections, func(i, j int) bool {
+		return newIndex.sections[i].lower < newIndex.sections[j].lower
+	})
+	sort.Slice(newIndex.sections6, func(i, j int) bool {
+		return newIndex.sections6[i].lower.less(newIndex.sections6[j].lower)
+	})
+	// 二分查找要求区段互不重叠
+	for i := 1; i < len(newIndex.sections); i++ {
+		if prev, next := newIndex.sections[i-1], newIndex.sections[i]; next.lower <= prev.upper {
+			return nil, fmt.Errorf("区段重叠: first=%s, second=%s",
+				uint128{lo: uint64(prev.lower)}.toIP(32), uint128{lo: uint64(next.lower)}.toIP(32))
+		}
+	}
+	for i := 1; i < len(newIndex.sections6); i++ {
+		if prev, next := newIndex.sections6[i-1], newIndex.sections6[i]; !prev.upper.less(next.lower) {
+			return nil, fmt.Errorf("区段重叠: first=%s, second=%s", prev.lower.toIP(128), next.lower.toIP(128))
+		}
+	}
+	newIndex.buildIndex()
+	newIndex.checksum = fmt.Sprintf("%x", sha1.Sum(data))
+	newIndex.format = "text"
+	return newIndex, nil
+}
@@ -0,0 +1,79 @@
+package ipipnet
+
+import (
+	"net"
+	"strings"
+	"testing"
+)
+
+func locationString(location *Location) string {
+	if location == nil {
+		return "<nil>"
+	}
+	return location.String()
+}
+
+func TestTextIPv6(t *testing.T) {
+	// 区段乱序出现，解析后排序
+	locator, err := newTestLocator(t, []byte("2001:db8:1::\t2001:db8:1:ffff:ffff:ffff:ffff:ffff\t中国\t\t\t\t\n"+
+		"1.0.0.0\t1.0.0.255\t中国\t广东\t广州\t\t电信\n"+
+		"2001:db8::\t2001:db8::ffff\t中国\t广东\t广州\t\t电信\n"+
+		"2001:db8:0:1::\t2001:db8:0:1::\t中国\t广东\t\t\t\n"), "ipipnet.txt", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for ip, want := range map[string]string{
+		"1.0.0.1":                             "中国(1)/广东(2)/广州(3)/电信(10)",
+		"::ffff:1.0.0.1":                      "中国(1)/广东(2)/广州(3)/电信(10)",
+		"2001:db8::":                          "中国(1)/广东(2)/广州(3)/电信(10)",
+		"2001:db8::ffff":                      "中国(1)/广东(2)/广州(3)/电信(10)",
+		"2001:db8::1:0":                       "<nil>",
+		"2001:db8:0:1::":                      "中国(1)/广东(2)",
+		"2001:db8:0:1::1":                     "<nil>",
+		"2001:db8:1:ffff:ffff:ffff:ffff:ffff": "中国(1)",
+		"2001:db8:2::":                        "<nil>",
+		"::1":                                 "<nil>",
+		"ffff::":                              "<nil>",
+	} {
+		location, err := locator.Locate(net.ParseIP(ip))
+		if err != nil {
+			t.Errorf("Locate(%s) error = %v", ip, err)
+		} else if got := locationString(location); got != want {
+			t.Errorf("Locate(%s) = %s; want %s", ip, got, want)
+		}
+	}
+}
+
+func TestTextNotIPv4(t *testing.T) {
+	locator, err := newTestLocator(t, []byte("1.0.0.0\t1.0.0.255\t中国\t\t\t\t\n"), "ipipnet.txt", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := locator.Locate(net.ParseIP("2001:db8::1")); err != ErrNotIPv4 {
+		t.Errorf("Locate(2001:db8::1) error = %v; want ErrNotIPv4", err)
+	}
+	// 映射地址按IPv4查找
+	if location, err := locator.Locate(net.ParseIP("::ffff:1.0.0.1")); err != nil || location.String() != "中国(1)" {
+		t.Errorf("Locate(::ffff:1.0.0.1) = %v, %v", location, err)
+	}
+}
+
+func TestTextInvalid(t *testing.T) {
+	for _, test := range []struct {
+		text string
+		want string
+	}{
+		{"1.0.0.0\t1.0.0.255\n", "错误的区段记录"},
+		{"1.0.0.0\tbad\t中国\t\t\t\t\n", "错误的区段地址"},
+		{"1.0.0.0\t2001:db8::\t中国\t\t\t\t\n", "区段地址族不一致"},
+		{"1.0.0.255\t1.0.0.0\t中国\t\t\t\t\n", "区段起始地址大于结束地址"},
+		{"2001:db8::ffff\t2001:db8::\t中国\t\t\t\t\n", "区段起始地址大于结束地址"},
+		{"1.0.1.0\t1.0.1.255\t中国\t\t\t\t\n1.0.0.0\t1.0.1.0\t中国\t\t\t\t\n", "区段重叠"},
+		{"1.0.0.0\t1.0.0.255\t中国\t\t\t\t\n1.0.0.0\t1.0.0.255\t中国\t\t\t\t\n", "区段重叠"},
+		{"2001:db8::\t2001:db8::ffff\t中国\t\t\t\t\n2001:db8::ff\t2001:db8::1:0\t中国\t\t\t\t\n", "区段重叠"},
+	} {
+		if _, err := parseText([]byte(test.text), newLocationBuilder(new(idTable), nil, nil)); err == nil || !strings.Contains(err.Error(), test.want) {
+			t.Errorf("text %q: error = %v; want %q", test.text, err, test.want)
+		}
+	}
+}
@@ -0,0 +1,103 @@
+package ipipnet
+
+import (
+	"fmt"
+)
+
+// trieWalker 遍历.ipdb和.mmdb共用的二叉前缀树：
+// 节点号小于nodeCount是内部节点，等于nodeCount表示无数据，大于nodeCount指向记录
+type trieWalker struct {
+	nodeCount int
+	readNode  func(node, bit int) int
+	resolve   func(node int) (*Location, error)
+	// 遍历IPv6时跳过的IPv4子树，以及指向它的别名
+	v4Node int
+	// 已访问的内部节点数，超过节点总数说明前缀树存在环
+	visited int
+}
+
+// walk 深度优先遍历前缀树，按地址顺序输出每个叶子对应的区段
+func (walker *trieWalker) walk(node, depth int, prefix uint128, bits int, emit func(uint128, uint128, *Location)) error {
+	if node == walker.nodeCount {
+		return nil
+	} else if node > walker.nodeCount {
+		location, err := walker.resolve(node)
+		if err != nil {
+			return err
+		}
+		lower, upper := prefixRange(prefix, depth, bits)
+		emit(lower, upper, location)
+		return nil
+	} else if depth >= bits {
+		return fmt.Errorf("前缀树深度超出%d位", bits)
+	} else if bits == 128 && depth > 0 && node == walker.v4Node {
+		return nil
+	}
+	if walker.visited++; walker.visited > walker.nodeCount*2 {
+		return fmt.Errorf("前缀树存在环")
+	}
+	if err := walker.walk(walker.readNode(node, 0), depth+1, prefix, bits, emit); err != nil {
+		return err
+	}
+	return walker.walk(walker.readNode(node, 1), depth+1, setBit(prefix, depth, bits), bits, emit)
+}
+
+// lookup 沿地址的各个位查找叶子节点，start为起始节点
+func (walker *trieWalker) lookup(start int, ip []byte) (int, error) {
+	node := start
+	for i := 0; i < len(ip)*8 && node < walker.nodeCount; i++ {
+		node = walker.readNode(node, int(ip[i>>3]>>uint(7-i&7))&1)
+	}
+	if node < walker.nodeCount {
+		return 0, fmt.Errorf("前缀树深度超出%d位", len(ip)*8)
+	}
+	return node, nil
+}
+
+// walkIndex 把前缀树展开为区段索引，v4Start为IPv4子树的根节点，小于0表示没有IPv4数据
+func (walker *trieWalker) walkIndex(index *ipIndex, v4Start int, ipv6 bool) error {
+	if v4Start >= 0 {
+		if err := walker.walk(v4Start, 0, uint128{}, 32, func(lower, upper uint128, location *Location) {
+			index.sections = append(index.sections, section{
+				Location: location,
+				lower:    uint32(lower.lo),
+				upper:    uint32(upper.lo),
+			})
+		}); err != nil {
+			return err
+		}
+	}
+	if ipv6 {
+		if err := walker.walk(0, 0, uint128{}, 128, func(lower, upper uint128, location *Location) {
+			index.sections6 = append(index.sections6, section6{
+				Location: location,
+				lower:    lower,
+				upper:    upper,
+			})
+		}); err != nil {
+			return err
+		}
+	}
+	index.buildIndex()
+	return nil
+}
+
+// prefixRange 返回前缀覆盖的地址范围，bits为32时只使用低32位
+func prefixRange(prefix uint128, depth, bits int) (uint128, uint128) {
+	upper := prefix
+	for i := depth; i < bits; i++ {
+		upper = setBit(upper, i, bits)
+	}
+	return prefix, upper
+}
+
+// setBit 设置从最高位数起的第i位
+func setBit(v uint128, i, bits int) uint128 {
+	pos := bits - 1 - i
+	if pos >= 64 {
+		v.hi |= 1 << uint(pos-64)
+	} else {
+		v.lo |= 1 << uint(pos)
+	}
+	return v
+}
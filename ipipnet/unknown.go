@@ -0,0 +1,119 @@
+package ipipnet
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// UnknownName 是数据中出现但ID文件没有收录的地点或ISP，
+// 地点名称为完整路径，如"中国/广东/广州"，Addresses只计算IPv4地址
+type UnknownName struct {
+	Name      string `json:"name"`
+	Ranges    int    `json:"ranges"`
+	Addresses uint64 `json:"addresses"`
+}
+
+// UnknownNames 返回当前数据中ID文件没有收录的地点和ISP，按影响的地址数从多到少排序
+func (locator *Locator) UnknownNames() (places, isps []UnknownName) {
+	data := locator.data.Load()
+	return append([]UnknownName(nil), data.unknownPlaces...), append([]UnknownName(nil), data.unknownISPs...)
+}
+
+// countUnknowns 统计每个未知名称影响的区段数和地址数
+func (builder *locationBuilder) countUnknowns(index *ipIndex) (places, isps []UnknownName) {
+	placeCounts := make(map[string]*UnknownName)
+	ispCounts := make(map[string]*UnknownName)
+	count := func(counts map[string]*UnknownName, name string, addresses uint64) {
+		if counts[name] == nil {
+			counts[name] = &UnknownName{Name: name}
+		}
+		counts[name].Ranges++
+		counts[name].Addresses += addresses
+	}
+	add := func(location *Location, addresses uint64) {
+		names := builder.unknownLocations[location]
+		if names == nil {
+			return
+		}
+		if names.place != "" {
+			count(placeCounts, names.place, addresses)
+		}
+		for _, isp := range names.isps {
+			count(ispCounts, isp, addresses)
+		}
+	}
+	for _, section := range index.sections {
+		add(section.Location, uint64(section.upper-section.lower)+1)
+	}
+	for _, section := range index.sections6 {
+		add(section.Location, 0)
+	}
+	return sortUnknowns(placeCounts), sortUnknowns(ispCounts)
+}
+
+func sortUnknowns(counts map[string]*UnknownName) []UnknownName {
+	result := make([]UnknownName, 0, len(counts))
+	for _, name := range counts {
+		result = append(result, *name)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Addresses != result[j].Addresses {
+			return result[i].Addresses > result[j].Addresses
+		}
+		return result[i].Name < result[j].Name
+	})
+	return result
+}
+
+// ProposeIDs 为当前数据中的未知名称生成可追加到ID文件的记录。
+// 已有ID保持不变，缺少的上级地点一并补充，地域和ISP的新ID分别从各自最大的ID之后依次分配
+func (locator *Locator) ProposeIDs() []string {
+	data := locator.data.Load()
+	var nextPlaceID, nextISPID uint64
+	for id := range data.ids.placeIDs {
+		if id > nextPlaceID {
+			nextPlaceID = id
+		}
+	}
+	for id := range data.ids.ispIDs {
+		if id > nextISPID {
+			nextISPID = id
+		}
+	}
+	var paths []string
+	for _, place := range data.unknownPlaces {
+		paths = append(paths, place.Name)
+	}
+	sort.Strings(paths)
+	var lines []string
+	proposed := make(map[string]bool)
+	for _, path := range paths {
+		names := strings.Split(path, "/")
+		places := data.ids.places
+		for i, name := range names {
+			if place, found := places[name]; found {
+				places = place.subplaces
+				continue
+			}
+			places = nil
+			key := strings.Join(names[:i+1], "/")
+			if proposed[key] {
+				continue
+			}
+			proposed[key] = true
+			nextPlaceID++
+			lines = append(lines, fmt.Sprintf("region,%s,%d", strings.Join(names[:i+1], ","), nextPlaceID))
+		}
+	}
+	var isps []string
+	for _, isp := range data.unknownISPs {
+		isps = append(isps, isp.Name)
+	}
+	sort.Strings(isps)
+	for _, name := range isps {
+		nextISPID++
+		lines = append(lines, fmt.Sprintf("isp,%s,%d", name, nextISPID))
+	}
+	return lines
+}
@@ -0,0 +1,80 @@
+package ipipnet
+
+import (
+	"io/ioutil"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const testUnknownText = "1.0.0.0\t1.0.0.255\t中国\t广西\t南宁\t\t联通\n" +
+	"1.0.1.0\t1.0.1.255\t中国\t广西\t南宁\t\t联通/电信\n" +
+	"2.0.0.0\t2.0.0.127\t美国\t\t\t\t\n" +
+	"2001::\t2001::ff\t美国\t\t\t\t\n"
+
+func TestUnknownNames(t *testing.T) {
+	var callbacks []string
+	locator, err := newTestLocator(t, []byte(testUnknownText), "ipipnet.dat", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	locator.config.UnknownPlaceCallback = func(name string) { callbacks = append(callbacks, name) }
+	locator.config.UnknownISPCallback = func(name string) { callbacks = append(callbacks, name) }
+	if err := locator.loadData(); err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"中国/广西/南宁", "联通", "美国"}; !reflect.DeepEqual(callbacks, want) {
+		t.Errorf("callbacks = %v; want %v", callbacks, want)
+	}
+	places, isps := locator.UnknownNames()
+	if want := []UnknownName{{"中国/广西/南宁", 2, 512}, {"美国", 2, 128}}; !reflect.DeepEqual(places, want) {
+		t.Errorf("places = %v; want %v", places, want)
+	}
+	if want := []UnknownName{{"联通", 2, 512}}; !reflect.DeepEqual(isps, want) {
+		t.Errorf("isps = %v; want %v", isps, want)
+	}
+
+	lines := locator.ProposeIDs()
+	want := []string{"region,中国,广西,4", "region,中国,广西,南宁,5", "region,美国,6", "isp,联通,11"}
+	if !reflect.DeepEqual(lines, want) {
+		t.Fatalf("ProposeIDs = %v; want %v", lines, want)
+	}
+	ids := testIDs + strings.Join(lines, "\n") + "\n"
+	if err := ioutil.WriteFile(locator.config.IDsPath, []byte(ids), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := locator.loadData(); err != nil {
+		t.Fatal(err)
+	}
+	if places, isps := locator.UnknownNames(); len(places)+len(isps) != 0 {
+		t.Errorf("after applying proposals places = %v, isps = %v", places, isps)
+	}
+	if place := locator.PlaceByPath("中国", "广西", "南宁"); place == nil || place.ID != 5 {
+		t.Errorf("PlaceByPath(中国, 广西, 南宁) = %v", place)
+	}
+}
+
+func TestProposeIDsLargePlaceID(t *testing.T) {
+	locator, err := newTestLocator(t, []byte(testUnknownText), "ipipnet.dat", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 地域ID为64位，ISP ID为32位，ISP的新ID不能从地域ID之后分配
+	ids := testIDs + "region,日本,4294967296\n"
+	if err := ioutil.WriteFile(locator.config.IDsPath, []byte(ids), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := locator.loadData(); err != nil {
+		t.Fatal(err)
+	}
+	lines := locator.ProposeIDs()
+	if want := "isp,联通,11"; len(lines) == 0 || lines[len(lines)-1] != want {
+		t.Fatalf("ProposeIDs = %v; want last %q", lines, want)
+	}
+	if err := ioutil.WriteFile(locator.config.IDsPath, []byte(ids+strings.Join(lines, "\n")+"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := locator.loadData(); err != nil {
+		t.Fatalf("loading proposed IDs: %v", err)
+	}
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+
+	"github.com/yangchenxing/foochow/ipipnet"
+	"github.com/yangchenxing/foochow/logging"
+)
+
+// runBuild 把Tab分隔的文本格式"起始IP\t结束IP\t地点和ISP文本"转换为.dat格式，IPv6记录被忽略
+func runBuild(args []string) error {
+	flags := flag.NewFlagSet("build", flag.ExitOnError)
+	input := flags.String("in", "", "文本格式输入文件，为空时读取标准输入")
+	output := flags.String("out", "", "输出的.dat文件路径")
+	flags.Parse(args)
+	if *output == "" {
+		return fmt.Errorf("未指定输出文件")
+	}
+	reader := os.Stdin
+	if *input != "" {
+		file, err := os.Open(*input)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		reader = file
+	}
+	var entries []ipipnet.DataEntry
+	skipped := 0
+	scanner := bufio.NewScanner(reader)
+	lineNum := 0
+	for scanner.Scan() {
+		lineNum++
+		fields := strings.SplitN(scanner.Text(), "\t", 3)
+		if len(fields) < 3 {
+			return fmt.Errorf("错误的记录: line=%d, text=%q", lineNum, scanner.Text())
+		}
+		start, end := net.ParseIP(fields[0]), net.ParseIP(fields[1])
+		if start == nil || end == nil {
+			return fmt.Errorf("错误的IP地址: line=%d, text=%q", lineNum, scanner.Text())
+		}
+		if start.To4() == nil {
+			skipped++
+			continue
+		}
+		entries = append(entries, ipipnet.DataEntry{Start: start, End: end, Text: fields[2]})
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	if skipped > 0 {
+		logging.Warn("忽略IPv6记录: count=%d", skipped)
+	}
+	file, err := os.Create(*output)
+	if err != nil {
+		return err
+	}
+	writer := bufio.NewWriter(file)
+	if err := ipipnet.WriteData(writer, entries); err != nil {
+		file.Close()
+		return err
+	}
+	if err := writer.Flush(); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+
+	"github.com/yangchenxing/foochow/ipipnet"
+)
+
+func runDiff(args []string) error {
+	flags := flag.NewFlagSet("diff", flag.ExitOnError)
+	idsPath := flags.String("ids", "data/ipipnet.ids", "ID文件路径")
+	oldPath := flags.String("old", "data/ipipnet.dat", "旧数据文件路径")
+	newPath := flags.String("new", "", "新数据文件路径")
+	format := flags.String("format", "text", "输出格式: text, json, csv")
+	flags.Parse(args)
+	if *newPath == "" {
+		return fmt.Errorf("未指定新数据文件")
+	}
+	diff, err := ipipnet.Compare(ipipnet.Config{IDsPath: *idsPath}, *oldPath, *newPath)
+	if err != nil {
+		return err
+	}
+	switch *format {
+	case "text":
+		for _, change := range diff.Changes {
+			fmt.Printf("%s-%s\t%d\t%s => %s\n", change.Start, change.End, change.Addresses,
+				locationString(change.Old), locationString(change.New))
+		}
+		fmt.Printf("\n变化IPv4地址: %d (%.4f%%)\n", diff.Addresses, diff.Ratio*100)
+		printGroups("国家", diff.Countries)
+		printGroups("省份", diff.Provinces)
+		printGroups("ISP", diff.ISPs)
+	case "json":
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(diff)
+	case "csv":
+		writer := csv.NewWriter(os.Stdout)
+		writer.Write([]string{"start", "end", "addresses", "old", "new"})
+		for _, change := range diff.Changes {
+			writer.Write([]string{change.Start.String(), change.End.String(),
+				strconv.FormatUint(change.Addresses, 10), locationString(change.Old), locationString(change.New)})
+		}
+		writer.Flush()
+		return writer.Error()
+	default:
+		return fmt.Errorf("不支持的输出格式: %q", *format)
+	}
+	return nil
+}
+
+func locationString(location *ipipnet.Location) string {
+	if location == nil {
+		return "-"
+	}
+	return location.String()
+}
+
+func printGroups(title string, groups []ipipnet.DiffGroup) {
+	if len(groups) == 0 {
+		return
+	}
+	fmt.Printf("\n%s:\n", title)
+	for _, group := range groups {
+		fmt.Printf("  %s\t-%d\t+%d\n", group.Name, group.Removed, group.Added)
+	}
+}
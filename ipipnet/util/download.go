@@ -0,0 +1,58 @@
+package main
+
+import (
+	"context"
+	"crypto/sha1"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/yangchenxing/foochow/ipipnet"
+)
+
+func runDownload(args []string) error {
+	flags := flag.NewFlagSet("download", flag.ExitOnError)
+	path := flags.String("data", "data/ipipnet.dat", "数据文件路径")
+	url := flags.String("url", "", "数据下载路径")
+	proxy := flags.String("proxy", "", "代理地址，为空时使用环境变量")
+	timeout := flags.Duration("timeout", 10*time.Minute, "下载超时")
+	force := flags.Bool("force", false, "忽略已有文件，总是下载")
+	flags.Parse(args)
+	if *url == "" {
+		return fmt.Errorf("未指定数据下载路径")
+	}
+	downloader, err := ipipnet.NewDownloader(*url, *path, *timeout, *proxy)
+	if err != nil {
+		return err
+	}
+	var checksum string
+	if !*force {
+		if checksum, err = fileChecksum(*path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	switch err := downloader.Download(context.Background(), checksum); err {
+	case nil:
+		fmt.Printf("下载完成: %s\n", *path)
+	case ipipnet.ErrDuplicatedDownload:
+		fmt.Printf("数据未更新: %s\n", *path)
+	default:
+		return err
+	}
+	return nil
+}
+
+func fileChecksum(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	hasher := sha1.New()
+	if _, err := io.Copy(hasher, file); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%x", hasher.Sum(nil)), nil
+}
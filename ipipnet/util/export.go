@@ -0,0 +1,50 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+)
+
+// runExport 把加载后的数据导出为CSV或MMDB，覆盖文件不参与导出
+func runExport(args []string) error {
+	flags := flag.NewFlagSet("export", flag.ExitOnError)
+	data := addDataFlags(flags)
+	format := flags.String("format", "csv", "导出格式: csv, mmdb")
+	output := flags.String("out", "", "输出文件路径，为空时写到标准输出")
+	language := flags.String("language", "zh-CN", "MMDB中名称使用的语言")
+	flags.Parse(args)
+	if *format != "csv" && *format != "mmdb" {
+		return fmt.Errorf("未知的导出格式: %q", *format)
+	}
+	locator, err := data.open()
+	if err != nil {
+		return err
+	}
+	defer locator.Close()
+	var out io.Writer = os.Stdout
+	var file *os.File
+	if *output != "" {
+		if file, err = os.Create(*output); err != nil {
+			return err
+		}
+		out = file
+	}
+	writer := bufio.NewWriter(out)
+	if *format == "csv" {
+		err = locator.ExportCSV(writer)
+	} else {
+		err = locator.ExportMMDB(writer, *language)
+	}
+	if err == nil {
+		err = writer.Flush()
+	}
+	if file != nil {
+		if closeErr := file.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	return err
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/yangchenxing/foochow/ipipnet"
+)
+
+// lookupWriter 按指定格式输出查询结果
+type lookupWriter interface {
+	write(ip string, location *ipipnet.Location, err error) error
+	flush() error
+}
+
+type textLookupWriter struct {
+	writer *bufio.Writer
+}
+
+func (writer textLookupWriter) write(ip string, location *ipipnet.Location, err error) error {
+	if err != nil {
+		_, err = fmt.Fprintf(writer.writer, "%s\t错误: %s\n", ip, err.Error())
+	} else {
+		_, err = fmt.Fprintf(writer.writer, "%s\t%s\n", ip, locationString(location))
+	}
+	return err
+}
+
+func (writer textLookupWriter) flush() error {
+	return writer.writer.Flush()
+}
+
+type jsonLookupWriter struct {
+	writer  *bufio.Writer
+	encoder *json.Encoder
+}
+
+func (writer jsonLookupWriter) write(ip string, location *ipipnet.Location, err error) error {
+	result := struct {
+		IP       string            `json:"ip"`
+		Location *ipipnet.Location `json:"location"`
+		Error    string            `json:"error,omitempty"`
+	}{
+		IP:       ip,
+		Location: location,
+	}
+	if err != nil {
+		result.Error = err.Error()
+	}
+	return writer.encoder.Encode(result)
+}
+
+func (writer jsonLookupWriter) flush() error {
+	return writer.writer.Flush()
+}
+
+type csvLookupWriter struct {
+	writer *csv.Writer
+}
+
+func (writer csvLookupWriter) write(ip string, location *ipipnet.Location, err error) error {
+	record := []string{ip, "", "", "", "", "", "", "", "", ""}
+	if err != nil {
+		record[9] = err.Error()
+	} else if location != nil {
+		record[1] = formatID(location.GetCountryID())
+		record[2] = location.GetCountryName()
+		record[3] = formatID(location.GetProvinceID())
+		record[4] = location.GetProvinceName()
+		record[5] = formatID(location.GetCityID())
+		record[6] = location.GetCityName()
+		ids := location.GetISPIDs()
+		texts := make([]string, len(ids))
+		for i, id := range ids {
+			texts[i] = formatID(id)
+		}
+		record[7] = strings.Join(texts, "/")
+		record[8] = strings.Join(location.GetISPNames(), "/")
+	}
+	return writer.writer.Write(record)
+}
+
+func (writer csvLookupWriter) flush() error {
+	writer.writer.Flush()
+	return writer.writer.Error()
+}
+
+func formatID(id uint64) string {
+	if id == 0 {
+		return ""
+	}
+	return strconv.FormatUint(id, 10)
+}
+
+func newLookupWriter(format string, w io.Writer) (lookupWriter, error) {
+	switch format {
+	case "text":
+		return textLookupWriter{bufio.NewWriter(w)}, nil
+	case "json":
+		buffered := bufio.NewWriter(w)
+		return jsonLookupWriter{buffered, json.NewEncoder(buffered)}, nil
+	case "csv":
+		// csv.Writer自带缓冲
+		writer := csv.NewWriter(w)
+		writer.Write([]string{"ip", "country_id", "country", "province_id", "province",
+			"city_id", "city", "isp_ids", "isps", "error"})
+		return csvLookupWriter{writer}, nil
+	}
+	return nil, fmt.Errorf("不支持的输出格式: %q", format)
+}
+
+func runLookup(args []string) error {
+	flags := flag.NewFlagSet("lookup", flag.ExitOnError)
+	data := addDataFlags(flags)
+	format := flags.String("format", "text", "输出格式: text, json, csv")
+	flags.Parse(args)
+	writer, err := newLookupWriter(*format, os.Stdout)
+	if err != nil {
+		return err
+	}
+	locator, err := data.open()
+	if err != nil {
+		return err
+	}
+	defer locator.Close()
+	lookup := func(text string) error {
+		text = strings.TrimSpace(text)
+		if text == "" {
+			return nil
+		}
+		ip := net.ParseIP(text)
+		if ip == nil {
+			return writer.write(text, nil, ipipnet.ErrInvalidIP)
+		}
+		location, err := locator.Locate(ip)
+		return writer.write(text, location, err)
+	}
+	if flags.NArg() > 0 {
+		for _, text := range flags.Args() {
+			if err := lookup(text); err != nil {
+				return err
+			}
+		}
+		return writer.flush()
+	}
+	scanner := bufio.NewScanner(os.Stdin)
+	for scanner.Scan() {
+		if err := lookup(scanner.Text()); err != nil {
+			return err
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	return writer.flush()
+}
@@ -1,40 +1,108 @@
 package main
 
 import (
-	"bufio"
 	"flag"
 	"fmt"
-	"net"
 	"os"
-	"time"
+	"sort"
+	"strings"
 
 	"github.com/yangchenxing/foochow/ipipnet"
 	"github.com/yangchenxing/foochow/logging"
 )
 
-var (
-	dataPath = flag.String("data", "data/ipipnet.dat", "数据文件路径")
-	idsPath  = flag.String("ids", "data/ipipnet.ids", "ID文件路径")
-	url      = flag.String("url", "", "数据下载路径")
-	interval = flag.Duration("interval", time.Minute, "检查更新周期")
-)
+type command struct {
+	usage string
+	run   func(args []string) error
+}
+
+var commands map[string]command
 
 func init() {
-	ipipnet.UnknownPlaceCallback = func(text string) {
-		logging.Warn("未知地点: %q", text)
+	commands = map[string]command{
+		"lookup":   {"查询IP，未指定IP时从标准输入逐行读取", runLookup},
+		"ranges":   {"反查地点或ISP的全部CIDR", runRanges},
+		"diff":     {"比较两个数据文件", runDiff},
+		"stats":    {"统计数据文件概况", runStats},
+		"validate": {"校验数据文件", runValidate},
+		"download": {"下载数据文件", runDownload},
+		"unknown":  {"列出ID文件未收录的地点和ISP", runUnknown},
+		"serve":    {"启动IP定位HTTP服务", runServe},
+		"build":    {"把文本格式数据转换为.dat文件", runBuild},
+		"export":   {"把数据导出为CSV或MMDB", runExport},
 	}
-	ipipnet.UnknownISPCallback = func(text string) {
-		logging.Warn("未知ISP: %q", text)
+}
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "用法: %s <命令> [参数]\n\n命令:\n", os.Args[0])
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(os.Stderr, "  %-10s %s\n", name, commands[name].usage)
 	}
 }
 
 func main() {
-	flag.Parse()
-	if err := ipipnet.Initialize(*idsPath, *dataPath, *url, *interval); err != nil {
-		logging.Error("初始化IPIP.net失败: %s", err.Error())
+	if len(os.Args) < 2 {
+		usage()
+		os.Exit(2)
+	}
+	command, found := commands[os.Args[1]]
+	if !found {
+		usage()
+		os.Exit(2)
+	}
+	if err := command.run(os.Args[2:]); err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		os.Exit(1)
+	}
+}
+
+// dataFlags 是各命令共用的数据文件参数
+type dataFlags struct {
+	dataPath *string
+	idsPath  *string
+	url      *string
+	override *string
+	columns  *string
+	index    *string
+}
+
+func addDataFlags(flags *flag.FlagSet) *dataFlags {
+	return &dataFlags{
+		dataPath: flags.String("data", "data/ipipnet.dat", "数据文件路径"),
+		idsPath:  flags.String("ids", "data/ipipnet.ids", "ID文件路径"),
+		url:      flags.String("url", "", "数据下载路径，数据文件不存在时下载"),
+		override: flags.String("override", "", "覆盖文件路径"),
+		columns:  flags.String("columns", "", "逗号分隔的数据列定义，为空时使用旧格式"),
+		index:    flags.String("index", "prefix8", "IPv4查找范围划分方式: prefix8, prefix16"),
+	}
+}
+
+func (data *dataFlags) open() (*ipipnet.Locator, error) {
+	return ipipnet.New(data.config())
+}
+
+func (data *dataFlags) config() ipipnet.Config {
+	var columns []string
+	if *data.columns != "" {
+		columns = strings.Split(*data.columns, ",")
 	}
-	scanner := bufio.NewScanner(os.Stdin)
-	for scanner.Scan() {
-		fmt.Println(ipipnet.Locate(net.ParseIP(scanner.Text())))
+	return ipipnet.Config{
+		IDsPath:      *data.idsPath,
+		DataPath:     *data.dataPath,
+		DataURL:      *data.url,
+		OverridePath: *data.override,
+		Columns:      columns,
+		Index:        *data.index,
+		UnknownPlaceCallback: func(text string) {
+			logging.Warn("未知地点: %q", text)
+		},
+		UnknownISPCallback: func(text string) {
+			logging.Warn("未知ISP: %q", text)
+		},
 	}
 }
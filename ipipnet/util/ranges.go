@@ -0,0 +1,75 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net"
+	"os"
+
+	"github.com/yangchenxing/foochow/ipipnet"
+)
+
+type cidrRange struct {
+	CIDR  string `json:"cidr"`
+	Start string `json:"start"`
+	End   string `json:"end"`
+}
+
+func newCIDRRange(ipnet *net.IPNet) cidrRange {
+	end := make(net.IP, len(ipnet.IP))
+	for i := range ipnet.IP {
+		end[i] = ipnet.IP[i] | ^ipnet.Mask[i]
+	}
+	return cidrRange{
+		CIDR:  ipnet.String(),
+		Start: ipnet.IP.String(),
+		End:   end.String(),
+	}
+}
+
+func runRanges(args []string) error {
+	flags := flag.NewFlagSet("ranges", flag.ExitOnError)
+	data := addDataFlags(flags)
+	var filter ipipnet.RangeFilter
+	flags.Uint64Var(&filter.CountryID, "country", 0, "国家ID")
+	flags.Uint64Var(&filter.ProvinceID, "province", 0, "省份ID")
+	flags.Uint64Var(&filter.CityID, "city", 0, "城市ID")
+	flags.Uint64Var(&filter.ISPID, "isp", 0, "ISP ID")
+	format := flags.String("format", "plain", "输出格式: plain, json, csv")
+	flags.Parse(args)
+	if filter == (ipipnet.RangeFilter{}) {
+		return fmt.Errorf("至少需要指定一个ID")
+	}
+	locator, err := data.open()
+	if err != nil {
+		return err
+	}
+	defer locator.Close()
+	ranges := make([]cidrRange, 0)
+	for _, ipnet := range locator.Ranges(filter) {
+		ranges = append(ranges, newCIDRRange(ipnet))
+	}
+	switch *format {
+	case "plain":
+		for _, r := range ranges {
+			fmt.Println(r.CIDR)
+		}
+	case "json":
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(ranges)
+	case "csv":
+		writer := csv.NewWriter(os.Stdout)
+		writer.Write([]string{"cidr", "start", "end"})
+		for _, r := range ranges {
+			writer.Write([]string{r.CIDR, r.Start, r.End})
+		}
+		writer.Flush()
+		return writer.Error()
+	default:
+		return fmt.Errorf("不支持的输出格式: %q", *format)
+	}
+	return nil
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"flag"
+	"net/http"
+	"time"
+
+	"github.com/yangchenxing/foochow/ipipnet"
+	"github.com/yangchenxing/foochow/ipipnet/httpapi"
+	"github.com/yangchenxing/foochow/logging"
+	"github.com/yangchenxing/foochow/stats/httpexp"
+)
+
+func runServe(args []string) error {
+	flags := flag.NewFlagSet("serve", flag.ExitOnError)
+	data := addDataFlags(flags)
+	listen := flags.String("listen", ":8080", "监听地址")
+	interval := flags.Duration("interval", time.Hour, "检查更新周期，需同时指定url")
+	trustForwardedFor := flags.Bool("trust-xff", false, "使用X-Forwarded-For中的客户端地址")
+	flags.Parse(args)
+	config := data.config()
+	config.CheckInterval = *interval
+	locator, err := ipipnet.New(config)
+	if err != nil {
+		return err
+	}
+	defer locator.Close()
+	handler := httpapi.NewHandler(locator, "ipipnet.http")
+	handler.TrustForwardedFor = *trustForwardedFor
+	http.Handle("/locate", handler)
+	statshttp.SetupHandler("/stats", time.Minute)
+	logging.Info("启动IP定位HTTP服务: listen=%s", *listen)
+	return http.ListenAndServe(*listen, nil)
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"sort"
+
+	"github.com/yangchenxing/foochow/ipipnet"
+)
+
+type countryStats struct {
+	Name      string `json:"name"`
+	Sections  int    `json:"sections"`
+	Addresses uint64 `json:"addresses"`
+}
+
+// datasetStats 不嵌入Metadata，否则Metadata.MarshalJSON会被提升，JSON中只剩元数据
+type datasetStats struct {
+	Metadata      *ipipnet.Metadata     `json:"metadata"`
+	Addresses     uint64                `json:"addresses"`
+	Locations     int                   `json:"locations"`
+	Countries     []*countryStats       `json:"countries"`
+	UnknownPlaces []ipipnet.UnknownName `json:"unknown_places"`
+	UnknownISPs   []ipipnet.UnknownName `json:"unknown_isps"`
+}
+
+func runStats(args []string) error {
+	flags := flag.NewFlagSet("stats", flag.ExitOnError)
+	data := addDataFlags(flags)
+	format := flags.String("format", "text", "输出格式: text, json")
+	top := flags.Int("top", 20, "输出IPv4地址数最多的国家数量")
+	flags.Parse(args)
+	locator, err := data.open()
+	if err != nil {
+		return err
+	}
+	defer locator.Close()
+	stats := &datasetStats{
+		Metadata: locator.Metadata(),
+	}
+	stats.UnknownPlaces, stats.UnknownISPs = locator.UnknownNames()
+	locations := make(map[string]bool)
+	countries := make(map[string]*countryStats)
+	locator.Walk(func(start, end net.IP, location *ipipnet.Location) bool {
+		locations[locationString(location)] = true
+		start4, end4 := start.To4(), end.To4()
+		if start4 == nil {
+			return true
+		}
+		addresses := uint64(ipToUint32(end4)-ipToUint32(start4)) + 1
+		stats.Addresses += addresses
+		name := location.GetCountryName()
+		if countries[name] == nil {
+			countries[name] = &countryStats{Name: name}
+		}
+		countries[name].Sections++
+		countries[name].Addresses += addresses
+		return true
+	})
+	stats.Locations = len(locations)
+	for _, country := range countries {
+		stats.Countries = append(stats.Countries, country)
+	}
+	sort.Slice(stats.Countries, func(i, j int) bool {
+		return stats.Countries[i].Addresses > stats.Countries[j].Addresses
+	})
+	if *top >= 0 && len(stats.Countries) > *top {
+		stats.Countries = stats.Countries[:*top]
+	}
+	switch *format {
+	case "text":
+		meta := stats.Metadata
+		fmt.Printf("文件: %s\n格式: %s\nSHA-1: %s\n", meta.Path, meta.Format, meta.Checksum)
+		if !meta.Build.IsZero() {
+			fmt.Printf("构建时间: %s\n", meta.Build.Format("2006-01-02 15:04:05"))
+		}
+		fmt.Printf("IPv4区段: %d\nIPv6区段: %d\nIPv4地址: %d\n不同位置: %d\n未知地点: %d\n未知ISP: %d\n",
+			meta.Sections, meta.Sections6, stats.Addresses, stats.Locations,
+			len(stats.UnknownPlaces), len(stats.UnknownISPs))
+		fmt.Println("\n国家\t区段\tIPv4地址")
+		for _, country := range stats.Countries {
+			name := country.Name
+			if name == "" {
+				name = "-"
+			}
+			fmt.Printf("%s\t%d\t%d\n", name, country.Sections, country.Addresses)
+		}
+	case "json":
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(stats)
+	default:
+		return fmt.Errorf("不支持的输出格式: %q", *format)
+	}
+	return nil
+}
+
+func ipToUint32(ip net.IP) uint32 {
+	return uint32(ip[0])<<24 | uint32(ip[1])<<16 | uint32(ip[2])<<8 | uint32(ip[3])
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/yangchenxing/foochow/ipipnet"
+)
+
+func runUnknown(args []string) error {
+	flags := flag.NewFlagSet("unknown", flag.ExitOnError)
+	data := addDataFlags(flags)
+	format := flags.String("format", "text", "输出格式: text, json")
+	propose := flags.Bool("propose", false, "输出可追加到ID文件的新记录")
+	flags.Parse(args)
+	locator, err := data.open()
+	if err != nil {
+		return err
+	}
+	defer locator.Close()
+	if *propose {
+		for _, line := range locator.ProposeIDs() {
+			fmt.Println(line)
+		}
+		return nil
+	}
+	places, isps := locator.UnknownNames()
+	switch *format {
+	case "text":
+		printUnknowns("region", places)
+		printUnknowns("isp", isps)
+	case "json":
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(map[string][]ipipnet.UnknownName{
+			"places": places,
+			"isps":   isps,
+		})
+	default:
+		return fmt.Errorf("不支持的输出格式: %q", *format)
+	}
+	return nil
+}
+
+func printUnknowns(kind string, names []ipipnet.UnknownName) {
+	for _, name := range names {
+		fmt.Printf("%s\t%s\tranges=%d\taddresses=%d\n", kind, name.Name, name.Ranges, name.Addresses)
+	}
+}
@@ -0,0 +1,26 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+)
+
+func runValidate(args []string) error {
+	flags := flag.NewFlagSet("validate", flag.ExitOnError)
+	data := addDataFlags(flags)
+	strict := flags.Bool("strict", false, "存在ID文件未收录的名称时视为错误")
+	flags.Parse(args)
+	locator, err := data.open()
+	if err != nil {
+		return err
+	}
+	defer locator.Close()
+	meta := locator.Metadata()
+	places, isps := locator.UnknownNames()
+	if *strict && len(places)+len(isps) > 0 {
+		return fmt.Errorf("存在未知名称: places=%d, isps=%d", len(places), len(isps))
+	}
+	fmt.Printf("OK: path=%s, format=%s, checksum=%s, sections=%d, sections6=%d\n",
+		meta.Path, meta.Format, meta.Checksum, meta.Sections, meta.Sections6)
+	return nil
+}
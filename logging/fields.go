@@ -0,0 +1,164 @@
+package logging
+
+import (
+	"fmt"
+	"strings"
+)
+
+// KVLogger 携带一组键值字段的日志记录器，字段按加入顺序输出
+type KVLogger struct {
+	fields []string
+}
+
+// With 返回携带字段的日志记录器，keyvals为交替的键和值，缺少值的键记为空字符串
+func With(keyvals ...interface{}) *KVLogger {
+	return &KVLogger{fields: appendFields(nil, keyvals)}
+}
+
+// With 返回在当前字段之后追加字段的新日志记录器，同名字段覆盖当前的值，原记录器不受影响
+func (logger *KVLogger) With(keyvals ...interface{}) *KVLogger {
+	fields := make([]string, len(logger.fields), len(logger.fields)+len(keyvals)+1)
+	copy(fields, logger.fields)
+	return &KVLogger{fields: appendFields(fields, keyvals)}
+}
+
+func appendFields(fields []string, keyvals []interface{}) []string {
+	for i := 0; i < len(keyvals); i += 2 {
+		key, ok := keyvals[i].(string)
+		if !ok {
+			key = fmt.Sprint(keyvals[i])
+		}
+		value := ""
+		if i+1 < len(keyvals) {
+			value = fmt.Sprint(keyvals[i+1])
+		}
+		fields = append(fields, key, value)
+	}
+	return fields
+}
+
+// uniqueFields 合并同名字段，保留第一次出现的位置和最后一次出现的值，
+// 因此子记录器With的字段覆盖父记录器的同名字段
+func uniqueFields(fields []string) []string {
+	result := make([]string, 0, len(fields))
+	for i := 0; i+1 < len(fields); i += 2 {
+		j := 0
+		for ; j < len(result); j += 2 {
+			if result[j] == fields[i] {
+				result[j+1] = fields[i+1]
+				break
+			}
+		}
+		if j == len(result) {
+			result = append(result, fields[i], fields[i+1])
+		}
+	}
+	return result
+}
+
+// formatFields 把字段格式化为"key=value key=value"，同名字段只输出最后一个值
+func formatFields(fields []string) string {
+	fields = uniqueFields(fields)
+	var builder strings.Builder
+	for i := 0; i+1 < len(fields); i += 2 {
+		if i > 0 {
+			builder.WriteByte(' ')
+		}
+		builder.WriteString(fields[i])
+		builder.WriteByte('=')
+		builder.WriteString(logfmtValue(fields[i+1]))
+	}
+	return builder.String()
+}
+
+func (logger *KVLogger) logf(level, format string, args []interface{}) {
+	if enabled(level) {
+		log(3, level, nil, logger.fields, fmt.Sprintf(format, args...))
+	}
+}
+
+func (logger *KVLogger) logKV(level, message string, keyvals []interface{}) {
+	if !enabled(level) {
+		return
+	}
+	fields := logger.fields
+	if len(keyvals) > 0 {
+		fields = appendFields(append([]string(nil), fields...), keyvals)
+	}
+	log(3, level, nil, fields, message)
+}
+
+func (logger *KVLogger) Log(level, format string, args ...interface{}) {
+	logger.logf(level, format, args)
+}
+
+func (logger *KVLogger) Debug(format string, args ...interface{}) {
+	logger.logf("debug", format, args)
+}
+
+func (logger *KVLogger) Info(format string, args ...interface{}) {
+	logger.logf("info", format, args)
+}
+
+func (logger *KVLogger) Warn(format string, args ...interface{}) {
+	logger.logf("warn", format, args)
+}
+
+func (logger *KVLogger) Error(format string, args ...interface{}) {
+	logger.logf("error", format, args)
+}
+
+func (logger *KVLogger) Fatal(format string, args ...interface{}) {
+	logger.logf("fatal", format, args)
+}
+
+// LogKV 记录不经格式化的消息，keyvals追加在记录器已有的字段之后
+func (logger *KVLogger) LogKV(level, message string, keyvals ...interface{}) {
+	logger.logKV(level, message, keyvals)
+}
+
+func (logger *KVLogger) DebugKV(message string, keyvals ...interface{}) {
+	logger.logKV("debug", message, keyvals)
+}
+
+func (logger *KVLogger) InfoKV(message string, keyvals ...interface{}) {
+	logger.logKV("info", message, keyvals)
+}
+
+func (logger *KVLogger) WarnKV(message string, keyvals ...interface{}) {
+	logger.logKV("warn", message, keyvals)
+}
+
+func (logger *KVLogger) ErrorKV(message string, keyvals ...interface{}) {
+	logger.logKV("error", message, keyvals)
+}
+
+func (logger *KVLogger) FatalKV(message string, keyvals ...interface{}) {
+	logger.logKV("fatal", message, keyvals)
+}
+
+var rootLogger = new(KVLogger)
+
+func LogKV(level, message string, keyvals ...interface{}) {
+	rootLogger.logKV(level, message, keyvals)
+}
+
+func DebugKV(message string, keyvals ...interface{}) {
+	rootLogger.logKV("debug", message, keyvals)
+}
+
+func InfoKV(message string, keyvals ...interface{}) {
+	rootLogger.logKV("info", message, keyvals)
+}
+
+func WarnKV(message string, keyvals ...interface{}) {
+	rootLogger.logKV("warn", message, keyvals)
+}
+
+func ErrorKV(message string, keyvals ...interface{}) {
+	rootLogger.logKV("error", message, keyvals)
+}
+
+func FatalKV(message string, keyvals ...interface{}) {
+	rootLogger.logKV("fatal", message, keyvals)
+}
@@ -0,0 +1,197 @@
+package logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"unicode"
+
+	"github.com/yangchenxing/foochow/structs"
+)
+
+// builtinKeys 是JSON和logfmt输出中内置字段的顺序
+var builtinKeys = []string{"time", "level", "message", "file", "line", "func", "host", "ip"}
+
+func init() {
+	// 注册Formatter工厂
+	structs.RegisterFactory(&FormatterFactory{})
+}
+
+// Formatter 把一条日志事件格式化为一行文本，不含结尾的换行符。
+// event包含内置字段和LogEx的custom字段，fields为按顺序交替的键值字段
+type Formatter interface {
+	Format(event map[string]string, fields []string) []byte
+}
+
+// TextFormatter 按模板输出，模板中的$name替换为同名字段
+type TextFormatter struct {
+	Template string
+	pattern  []string
+}
+
+func (formatter *TextFormatter) initialize() {
+	submatches := holderPattern.FindAllString(formatter.Template, -1)
+	texts := holderPattern.Split(formatter.Template, -1)
+	formatter.pattern = make([]string, len(submatches)+len(texts))
+	for i := 0; i < len(formatter.pattern); i++ {
+		if i%2 == 0 {
+			formatter.pattern[i] = texts[i/2]
+		} else {
+			formatter.pattern[i] = submatches[i/2][1:]
+		}
+	}
+}
+
+// lookup 依次查找内置和custom字段、$fields以及最后一个同名的键值字段
+func (formatter *TextFormatter) lookup(event map[string]string, fields []string, name string) string {
+	if value, found := event[name]; found {
+		return value
+	} else if name == "fields" {
+		return formatFields(fields)
+	}
+	for i := len(fields)/2*2 - 2; i >= 0; i -= 2 {
+		if fields[i] == name {
+			return fields[i+1]
+		}
+	}
+	return ""
+}
+
+func (formatter *TextFormatter) Format(event map[string]string, fields []string) []byte {
+	var buf bytes.Buffer
+	for i, text := range formatter.pattern {
+		if i%2 == 0 {
+			buf.WriteString(text)
+		} else {
+			buf.WriteString(formatter.lookup(event, fields, text))
+		}
+	}
+	return buf.Bytes()
+}
+
+// JSONFormatter 每条日志输出一个JSON对象
+type JSONFormatter struct{}
+
+func (formatter *JSONFormatter) Format(event map[string]string, fields []string) []byte {
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	encoder.SetEscapeHTML(false)
+	buf.WriteByte('{')
+	for i, pair := range eventPairs(event, fields) {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		// Encode在末尾追加换行符，需要去掉
+		encoder.Encode(pair[0])
+		buf.Truncate(buf.Len() - 1)
+		buf.WriteByte(':')
+		encoder.Encode(pair[1])
+		buf.Truncate(buf.Len() - 1)
+	}
+	buf.WriteByte('}')
+	return buf.Bytes()
+}
+
+// LogfmtFormatter 以"key=value"的形式输出
+type LogfmtFormatter struct{}
+
+func (formatter *LogfmtFormatter) Format(event map[string]string, fields []string) []byte {
+	var buf bytes.Buffer
+	for i, pair := range eventPairs(event, fields) {
+		if i > 0 {
+			buf.WriteByte(' ')
+		}
+		buf.WriteString(logfmtValue(pair[0]))
+		buf.WriteByte('=')
+		buf.WriteString(logfmtValue(pair[1]))
+	}
+	return buf.Bytes()
+}
+
+// eventPairs 按内置字段、custom字段（按键排序）、键值字段的顺序列出全部字段。
+// 重复的键值字段保留第一次出现的位置和最后一次出现的值，与内置或custom字段同名的键值字段加"fields."前缀
+func eventPairs(event map[string]string, fields []string) [][2]string {
+	pairs := make([][2]string, 0, len(event)+len(fields)/2)
+	for _, key := range builtinKeys {
+		if value, found := event[key]; found {
+			pairs = append(pairs, [2]string{key, value})
+		}
+	}
+	custom := make([]string, 0, len(event)-len(pairs))
+	for key := range event {
+		if !isBuiltinKey(key) {
+			custom = append(custom, key)
+		}
+	}
+	sort.Strings(custom)
+	for _, key := range custom {
+		pairs = append(pairs, [2]string{key, event[key]})
+	}
+	positions := make(map[string]int, len(fields)/2)
+	for i := 0; i+1 < len(fields); i += 2 {
+		key := fields[i]
+		if _, found := event[key]; found {
+			key = "fields." + key
+		}
+		if position, found := positions[key]; found {
+			pairs[position][1] = fields[i+1]
+		} else {
+			positions[key] = len(pairs)
+			pairs = append(pairs, [2]string{key, fields[i+1]})
+		}
+	}
+	return pairs
+}
+
+func isBuiltinKey(key string) bool {
+	for _, builtin := range builtinKeys {
+		if key == builtin {
+			return true
+		}
+	}
+	return false
+}
+
+// logfmtValue 对空值以及含空白、引号、等号或控制字符的值加引号并转义
+func logfmtValue(value string) string {
+	if value == "" {
+		return `""`
+	}
+	for _, r := range value {
+		if r == '"' || r == '=' || unicode.IsSpace(r) || !unicode.IsPrint(r) {
+			return strconv.Quote(value)
+		}
+	}
+	return value
+}
+
+type FormatterFactory struct{}
+
+func (factory *FormatterFactory) GetInstanceType() reflect.Type {
+	return reflect.TypeOf((*Formatter)(nil)).Elem()
+}
+
+func (factory *FormatterFactory) Create(data map[string]interface{}) (interface{}, error) {
+	if typeName, ok := data["type"].(string); ok {
+		switch typeName {
+		case "text":
+			formatter := new(TextFormatter)
+			if err := structs.UnmarshalMap(formatter, data); err != nil {
+				return nil, err
+			}
+			formatter.initialize()
+			return formatter, nil
+		case "json":
+			return new(JSONFormatter), nil
+		case "logfmt":
+			return new(LogfmtFormatter), nil
+		default:
+			return nil, fmt.Errorf("未知日志Formatter类型: %q", typeName)
+		}
+	}
+	return nil, errors.New("缺少\"type\"字段")
+}
@@ -0,0 +1,107 @@
+package logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/yangchenxing/foochow/structs"
+)
+
+var testEvent = map[string]string{
+	"time":    "2016-01-02:15:04:05+0800",
+	"level":   "info",
+	"message": "第一行\n第二行 \"<b>\"",
+	"file":    "main.go",
+	"line":    "10",
+	"func":    "main.main",
+	"host":    "host",
+	"ip":      "10.0.0.1",
+	"trace":   "t1",
+}
+
+var testFields = []string{"user", "张三", "level", "x", "user", "李四", "empty", ""}
+
+func TestJSONFormatter(t *testing.T) {
+	text := string(new(JSONFormatter).Format(testEvent, testFields))
+	expected := `{"time":"2016-01-02:15:04:05+0800","level":"info","message":"第一行\n第二行 \"<b>\"",` +
+		`"file":"main.go","line":"10","func":"main.main","host":"host","ip":"10.0.0.1","trace":"t1",` +
+		`"user":"李四","fields.level":"x","empty":""}`
+	if text != expected {
+		t.Fatalf("expected %s, got %s", expected, text)
+	}
+	var values map[string]string
+	if err := json.Unmarshal([]byte(text), &values); err != nil {
+		t.Fatal(err)
+	} else if values["message"] != testEvent["message"] {
+		t.Fatalf("unexpected message: %q", values["message"])
+	}
+}
+
+func TestTextFormatter(t *testing.T) {
+	formatter := &TextFormatter{Template: "$level $user $trace [$fields] $missing"}
+	formatter.initialize()
+	text := string(formatter.Format(testEvent, testFields))
+	expected := `info 李四 t1 [user=李四 level=x empty=""] `
+	if text != expected {
+		t.Fatalf("expected %s, got %s", expected, text)
+	}
+}
+
+func TestLogfmtFormatter(t *testing.T) {
+	text := string(new(LogfmtFormatter).Format(testEvent, testFields))
+	expected := `time=2016-01-02:15:04:05+0800 level=info message="第一行\n第二行 \"<b>\"" file=main.go line=10 ` +
+		`func=main.main host=host ip=10.0.0.1 trace=t1 user=李四 fields.level=x empty=""`
+	if text != expected {
+		t.Fatalf("expected %s, got %s", expected, text)
+	}
+}
+
+func TestFormatterConfig(t *testing.T) {
+	var config Config
+	err := structs.UnmarshalMap(&config, map[string]interface{}{
+		"Handlers": []interface{}{
+			map[string]interface{}{
+				"Levels":    []interface{}{"fmttest"},
+				"Formatter": map[string]interface{}{"type": "logfmt"},
+			},
+			map[string]interface{}{
+				"Levels":    []interface{}{"fmttest"},
+				"Formatter": map[string]interface{}{"type": "json"},
+			},
+			map[string]interface{}{
+				"Levels": []interface{}{"fmttest"},
+				"Format": "$level $message",
+			},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	for _, handler := range config.Handlers {
+		handler.Writers = []Writer{&buf}
+		handler.initialize()
+		AddHandler(handler)
+	}
+	With("user", "张三").LogKV("fmttest", "a\nb")
+	lines := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+	if len(lines) != 4 {
+		t.Fatalf("unexpected output: %q", buf.String())
+	}
+	if !strings.HasPrefix(lines[0], "time=") || !strings.HasSuffix(lines[0], ` user=张三`) ||
+		!strings.Contains(lines[0], `message="a\nb"`) {
+		t.Fatalf("unexpected logfmt output: %q", lines[0])
+	}
+	var values map[string]string
+	if err := json.Unmarshal([]byte(lines[1]), &values); err != nil {
+		t.Fatal(err)
+	} else if values["user"] != "张三" || values["message"] != "a\nb" || values["level"] != "fmttest" {
+		t.Fatalf("unexpected json output: %q", lines[1])
+	}
+	// 文本模板不转义换行
+	if lines[2] != "fmttest a" || lines[3] != "b" {
+		t.Fatalf("unexpected text output: %q", lines[2:])
+	}
+}
@@ -1,7 +1,6 @@
 package logging
 
 import (
-	"bytes"
 	"io"
 	"regexp"
 )
@@ -13,36 +12,25 @@ var (
 type Writer io.Writer
 
 type Handler struct {
-	Levels  []string
-	Format  string
-	Writers []Writer
-	pattern []string
+	Levels []string
+	// Format中的$name替换为事件字段: level, message, file, line, time, func, host, ip,
+	// 以及全部键值字段$fields和单个键值字段$键名
+	Format string
+	// Formatter为空时按Format输出，可以配置为{type = "json"}、{type = "logfmt"}或{type = "text", Template = "..."}
+	Formatter Formatter
+	Writers   []Writer
 }
 
 func (handler *Handler) initialize() {
-	submatches := holderPattern.FindAllString(handler.Format, -1)
-	texts := holderPattern.Split(handler.Format, -1)
-	handler.pattern = make([]string, len(submatches)+len(texts))
-	for i := 0; i < len(handler.pattern); i++ {
-		if i%2 == 0 {
-			handler.pattern[i] = texts[i/2]
-		} else {
-			handler.pattern[i] = submatches[i/2][1:]
-		}
+	if handler.Formatter == nil {
+		formatter := &TextFormatter{Template: handler.Format}
+		formatter.initialize()
+		handler.Formatter = formatter
 	}
 }
 
-func (handler *Handler) write(event map[string]string) error {
-	var buf bytes.Buffer
-	for i, text := range handler.pattern {
-		if i%2 == 0 {
-			buf.WriteString(text)
-		} else {
-			buf.WriteString(event[text])
-		}
-	}
-	buf.WriteRune('\n')
-	text := buf.Bytes()
+func (handler *Handler) write(event map[string]string, fields []string) error {
+	text := append(handler.Formatter.Format(event, fields), '\n')
 	for _, writer := range handler.Writers {
 		if _, err := writer.Write(text); err != nil {
 			return err
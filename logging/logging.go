@@ -68,6 +68,18 @@ func AddHandler(handler *Handler) {
 }
 
 func LogEx(skip int, level string, custom map[string]string, format string, args ...interface{}) {
+	if !enabled(level) {
+		return
+	}
+	log(skip+1, level, custom, nil, fmt.Sprintf(format, args...))
+}
+
+func enabled(level string) bool {
+	return len(handlers[level]) > 0 || len(handlers["*"]) > 0
+}
+
+// log 采集调用位置并交给handler，fields为按顺序交替的键和值
+func log(skip int, level string, custom map[string]string, fields []string, message string) {
 	targetHandlers := handlers[level]
 	wildHandlers := handlers["*"]
 	if len(targetHandlers) == 0 && len(wildHandlers) == 0 {
@@ -88,7 +100,7 @@ func LogEx(skip int, level string, custom map[string]string, format string, args
 	}
 	event := map[string]string{
 		"level":   level,
-		"message": fmt.Sprintf(format, args...),
+		"message": message,
 		"file":    file,
 		"line":    strconv.Itoa(line),
 		"time":    time.Now().Format("2006-01-02:15:04:05-0700"),
@@ -100,10 +112,10 @@ func LogEx(skip int, level string, custom map[string]string, format string, args
 		event[key] = value
 	}
 	for _, handler := range targetHandlers {
-		handler.write(event)
+		handler.write(event, fields)
 	}
 	for _, handler := range wildHandlers {
-		handler.write(event)
+		handler.write(event, fields)
 	}
 }
 
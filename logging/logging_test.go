@@ -0,0 +1,49 @@
+package logging
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func newTestHandler(level, format string) *bytes.Buffer {
+	var buf bytes.Buffer
+	handler := &Handler{
+		Levels:  []string{level},
+		Format:  format,
+		Writers: []Writer{&buf},
+	}
+	handler.initialize()
+	AddHandler(handler)
+	return &buf
+}
+
+func TestKVLogger(t *testing.T) {
+	buf := newTestHandler("kvtest", "$message|$fields|$request_id|$user|$level|$func")
+	logger := With("request_id", 42, "user", "张 三")
+	logger.LogKV("kvtest", "查询完成", "error", errors.New("not found"), "level", "x", "odd")
+	expected := "查询完成|request_id=42 user=\"张 三\" error=\"not found\" level=x odd=\"\"|42|张 三|kvtest|github.com/yangchenxing/foochow/logging.TestKVLogger\n"
+	if buf.String() != expected {
+		t.Fatalf("expected %q, got %q", expected, buf.String())
+	}
+	// 子记录器的同名字段覆盖父记录器，且不影响原记录器
+	buf.Reset()
+	logger.With("user", "李四").Log("kvtest", "%d%%", 100)
+	logger.Log("kvtest", "done")
+	expected = "100%|request_id=42 user=李四|42|李四|kvtest|github.com/yangchenxing/foochow/logging.TestKVLogger\n" +
+		"done|request_id=42 user=\"张 三\"|42|张 三|kvtest|github.com/yangchenxing/foochow/logging.TestKVLogger\n"
+	if buf.String() != expected {
+		t.Fatalf("expected %q, got %q", expected, buf.String())
+	}
+}
+
+func TestLogKV(t *testing.T) {
+	buf := newTestHandler("kvtest2", "$message|$fields|$func")
+	LogKV("kvtest2", "100%", "a", 1)
+	LogEx(1, "kvtest2", map[string]string{"fields": "custom"}, "%s", "x")
+	expected := "100%|a=1|github.com/yangchenxing/foochow/logging.TestLogKV\n" +
+		"x|custom|github.com/yangchenxing/foochow/logging.TestLogKV\n"
+	if buf.String() != expected {
+		t.Fatalf("expected %q, got %q", expected, buf.String())
+	}
+}
@@ -3,6 +3,7 @@ package stats
 import (
 	"container/list"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -11,9 +12,10 @@ const (
 )
 
 var (
-	items      = make(map[string]StatsItem)
-	data       = list.New()
-	current    int
+	items   = make(map[string]StatsItem)
+	data    = list.New()
+	current atomic.Int64
+	// rrdLock 保护items和data，计数器的数据由计数器自身的锁保护
 	rrdLock    sync.Mutex
 	serialSize = defaultSerialSize
 )
@@ -22,21 +24,23 @@ func init() {
 	go func() {
 		for {
 			time.Sleep(time.Second)
-			next := (current + 1) % serialSize
+			rrdLock.Lock()
+			next := (current.Load() + 1) % int64(serialSize)
 			for elem := data.Front(); elem != nil; elem = elem.Next() {
-				(elem.Value.([]float64))[next] = 0
+				counter := elem.Value.(*Counter)
+				counter.Lock()
+				counter.values[next] = 0
+				counter.Unlock()
 			}
-			rrdLock.Lock()
-			current = next
+			current.Store(next)
 			rrdLock.Unlock()
 		}
 	}()
 }
 
-func newData() []float64 {
-	values := make([]float64, serialSize)
-	data.PushBack(values)
-	return values
+// addCounter 把计数器加入定时清零的列表，调用时须持有rrdLock
+func addCounter(counter *Counter) {
+	data.PushBack(counter)
 }
 
 func registerItem(name string, item StatsItem) {
@@ -52,6 +56,8 @@ func LockRRD(callback func()) {
 }
 
 func GetStatsItem(name string) StatsItem {
+	rrdLock.Lock()
+	defer rrdLock.Unlock()
 	return items[name]
 }
 
@@ -12,10 +12,25 @@ type StatsItem interface {
 func NewCounter(name string) *Counter {
 	rrdLock.Lock()
 	defer rrdLock.Unlock()
+	return newCounter(name)
+}
+
+// GetOrNewCounter 返回已注册的计数器，不存在时新建，查找和注册在同一次加锁内完成
+func GetOrNewCounter(name string) *Counter {
+	rrdLock.Lock()
+	defer rrdLock.Unlock()
+	if counter, ok := items[name].(*Counter); ok {
+		return counter
+	}
+	return newCounter(name)
+}
 
+// newCounter 调用时须持有rrdLock
+func newCounter(name string) *Counter {
 	counter := &Counter{
-		values: newData(),
+		values: make([]float64, serialSize),
 	}
+	addCounter(counter)
 	registerItem(name, counter)
 	return counter
 }
@@ -29,7 +44,7 @@ func (counter *Counter) Add(value float64) {
 	counter.Lock()
 	defer counter.Unlock()
 
-	counter.values[current] += value
+	counter.values[current.Load()] += value
 }
 
 func (counter *Counter) Get(duration time.Duration) float64 {
@@ -40,7 +55,7 @@ func (counter *Counter) Get(duration time.Duration) float64 {
 	if count > serialSize-1 {
 		count = serialSize - 1
 	}
-	pos := (current - count + serialSize) % serialSize
+	pos := (int(current.Load()) - count + serialSize) % serialSize
 	sum := float64(0)
 	for i := 0; i < count; i++ {
 		sum += counter.values[pos]
@@ -50,9 +65,26 @@ func (counter *Counter) Get(duration time.Duration) float64 {
 }
 
 func NewAverager(name, sumName, countName string) *Averager {
+	rrdLock.Lock()
+	defer rrdLock.Unlock()
+	return newAverager(name, sumName, countName)
+}
+
+// GetOrNewAverager 返回已注册的平均值统计，不存在时新建，查找和注册在同一次加锁内完成
+func GetOrNewAverager(name, sumName, countName string) *Averager {
+	rrdLock.Lock()
+	defer rrdLock.Unlock()
+	if averager, ok := items[name].(*Averager); ok {
+		return averager
+	}
+	return newAverager(name, sumName, countName)
+}
+
+// newAverager 调用时须持有rrdLock
+func newAverager(name, sumName, countName string) *Averager {
 	averager := &Averager{
-		sum:   NewCounter(sumName),
-		count: NewCounter(countName),
+		sum:   newCounter(sumName),
+		count: newCounter(countName),
 	}
 	registerItem(name, averager)
 	return averager
@@ -106,9 +138,11 @@ func (averager *Averager) GetCount(duration time.Duration) uint64 {
 }
 
 func NewRatio(name, positiveName, totalName string) *Ratio {
+	rrdLock.Lock()
+	defer rrdLock.Unlock()
 	ratio := &Ratio{
-		positive: NewCounter(positiveName),
-		total:    NewCounter(totalName),
+		positive: newCounter(positiveName),
+		total:    newCounter(totalName),
 	}
 	registerItem(name, ratio)
 	return ratio
@@ -177,8 +211,10 @@ func (ratio *Ratio) GetTotal(duration time.Duration) uint64 {
 }
 
 func NewFrequency(name, counterName string) *Frequency {
+	rrdLock.Lock()
+	defer rrdLock.Unlock()
 	frequency := &Frequency{
-		Counter: NewCounter(counterName),
+		Counter: newCounter(counterName),
 	}
 	registerItem(name, frequency)
 	return frequency
@@ -199,6 +235,8 @@ func (frequency *Frequency) Get(duration time.Duration) float64 {
 type Value float64
 
 func NewValue(name string) *Value {
+	rrdLock.Lock()
+	defer rrdLock.Unlock()
 	value := new(Value)
 	registerItem(name, value)
 	return value
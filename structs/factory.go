@@ -28,7 +28,14 @@ func CreateByFactory(typ reflect.Type, data map[string]interface{}) (interface{}
 	return nil, fmt.Errorf("unregistered type: %q", typeName)
 }
 
+func hasFactory(typ reflect.Type) bool {
+	return factories[getTypeName(typ)] != nil
+}
+
 func getTypeName(typ reflect.Type) string {
+	if typ.Kind() == reflect.Ptr {
+		return "*" + getTypeName(typ.Elem())
+	}
 	if pkg := typ.PkgPath(); pkg != "" {
 		return pkg + "." + typ.Name()
 	} else {
@@ -232,6 +232,10 @@ func unmarshalInterface(dest, src reflect.Value) error {
 		dest.Set(src)
 		return nil
 	}
+	return unmarshalByFactory(dest, src)
+}
+
+func unmarshalByFactory(dest, src reflect.Value) error {
 	if data, ok := src.Interface().(map[string]interface{}); !ok {
 		return badtype("map[string]interface{}", src)
 	} else if instance, err := CreateByFactory(dest.Type(), data); err != nil {
@@ -330,6 +334,12 @@ func unmarshalStruct(dest, src reflect.Value) error {
 		}
 		switch field.Type.Kind() {
 		case reflect.Ptr:
+			if hasFactory(field.Type) {
+				if err := unmarshalByFactory(dest.Field(i), reflect.ValueOf(value)); err != nil {
+					return fmt.Errorf("unmarshal field %s fail: %s", field.Name, err.Error())
+				}
+				continue
+			}
 			if dest.Field(i).IsNil() {
 				dest.Field(i).Set(reflect.New(field.Type.Elem()))
 			}
@@ -350,6 +360,9 @@ func unmarshalStruct(dest, src reflect.Value) error {
 }
 
 func unmarshalPtr(dest, src reflect.Value) error {
+	if hasFactory(dest.Type()) {
+		return unmarshalByFactory(dest, src)
+	}
 	if dest.IsNil() {
 		dest.Set(reflect.New(dest.Type().Elem()))
 	}